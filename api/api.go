@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"errors"
 	"github.com/cristalhq/jwt/v4"
 	"io"
 	"time"
@@ -18,12 +19,31 @@ const (
 	MsgFullBody = "msg.fullBody"
 	// MsgId keys the message id
 	MsgId = "msg.id"
+	// MsgHandled keys the configuration keys of the handlers that claimed the message as a []string
+	MsgHandled = "msg.handled"
 
 	Stomp = "stomp"
 
 	Client = "client"
 )
 
+var (
+	// TokenMissingErr indicates that a message does not carry a JWT, but one is required
+	TokenMissingErr = errors.New("jwt missing")
+	// TokenInvalidErr indicates that a JWT is malformed or its signature could not be verified, e.g. it is forged
+	TokenInvalidErr = errors.New("jwt invalid")
+	// TokenExpiredErr indicates that a JWT has expired
+	TokenExpiredErr = errors.New("jwt expired")
+	// TokenNotYetValidErr indicates that a JWT is used before its not-before time
+	TokenNotYetValidErr = errors.New("jwt not yet valid")
+	// TokenIssuerErr indicates that a JWT was not issued by the expected issuer
+	TokenIssuerErr = errors.New("jwt issuer not accepted")
+	// TokenAudienceErr indicates that a JWT is not intended for any of the expected audiences
+	TokenAudienceErr = errors.New("jwt audience not accepted")
+	// TimeoutErr indicates that a handler did not complete within its configured timeout
+	TimeoutErr = errors.New("handler timed out")
+)
+
 type Proto string
 
 type AckMode string
@@ -45,10 +65,28 @@ type Listener interface {
 	Listen(ctx context.Context, handlers []Handler) error
 }
 
+// Handler performs some action based on a received message.  A Handler that processes the message (e.g. produces a
+// derivative) claims the message by returning a context produced by Handled.  Messages that are not claimed by any
+// Handler are not acknowledged as successful.
 type Handler interface {
 	Handle(ctx context.Context, t *jwt.Token, b *MessageBody) (context.Context, error)
 }
 
+// Handled answers a copy of ctx which records that the handler identified by key claimed the message.
+func Handled(ctx context.Context, key string) context.Context {
+	claimedBy := HandledBy(ctx)
+	return context.WithValue(ctx, MsgHandled, append(claimedBy[:len(claimedBy):len(claimedBy)], key))
+}
+
+// HandledBy answers the keys of the handlers that claimed the message, in the order they were claimed.  If no handler
+// has claimed the message, the result is empty.
+func HandledBy(ctx context.Context) []string {
+	if claimedBy, ok := ctx.Value(MsgHandled).([]string); ok {
+		return claimedBy
+	}
+	return []string{}
+}
+
 type Dialer interface {
 	Dial(host string, port int, timeout time.Duration) (Connection, error)
 }
@@ -59,4 +97,4 @@ type Subscriber interface {
 
 type Connection interface {
 	io.Closer
-}
\ No newline at end of file
+}
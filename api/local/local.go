@@ -0,0 +1,202 @@
+package local
+
+import (
+	"context"
+	"derivative-ms/api"
+	"derivative-ms/logging"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cristalhq/jwt/v4"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	sourcePath      = "/source/"
+	destinationPath = "/destination"
+)
+
+// UnhandledErr indicates that the message was not claimed by any handler
+var UnhandledErr = errors.New("local: message was not handled by any handler")
+
+// Result records the outcome of a single handler
+type Result struct {
+	Handler api.Handler
+	// Claimed are the configuration keys recorded by api.Handled while the handler handled the message; empty if the
+	// handler did not claim the message
+	Claimed []string
+	Elapsed time.Duration
+	Err     error
+}
+
+// Listener is an api.Listener which handles a single message supplied by the caller, without a broker.  The message is
+// handled exactly as if it had been received from Destination, but it is not acknowledged or redelivered.
+type Listener struct {
+	MessageId   string
+	Destination string
+	// Token is the JWT carried by the message, and may be nil
+	Token *jwt.Token
+	// Body is the JSON message body, which has the shape of an api.MessageBody
+	Body []byte
+	// Report, if not nil, is invoked with the Result of each handler, in the order the handlers are executed
+	Report func(r Result)
+}
+
+// Listen runs the message through handlers, stopping at the first handler that fails.  Listen answers the error of
+// that handler, or an error wrapping UnhandledErr if the message was not claimed by any handler.
+func (l *Listener) Listen(ctx context.Context, handlers []api.Handler) error {
+	var (
+		body     = &api.MessageBody{}
+		fullBody = map[string]interface{}{}
+	)
+
+	if err := json.Unmarshal(l.Body, body); err != nil {
+		return fmt.Errorf("local: unable to parse message body: %w", err)
+	}
+	if err := json.Unmarshal(l.Body, &fullBody); err != nil {
+		return fmt.Errorf("local: unable to parse message body: %w", err)
+	}
+
+	logger := logging.FromContext(ctx).With(
+		logging.FieldMessageId, l.MessageId,
+		logging.FieldDestination, l.Destination,
+		logging.FieldSourceUri, body.Attachment.Content.SourceUri)
+
+	msgCtx := logging.WithLogger(ctx, logger)
+	msgCtx = context.WithValue(msgCtx, api.MsgId, l.MessageId)
+	msgCtx = context.WithValue(msgCtx, api.MsgDestination, l.Destination)
+	msgCtx = context.WithValue(msgCtx, api.MsgJwt, l.Token)
+	msgCtx = context.WithValue(msgCtx, api.MsgFullBody, &fullBody)
+	msgCtx = context.WithValue(msgCtx, api.MsgBody, body)
+
+	for _, h := range handlers {
+		var (
+			// a handler may replace the token, e.g. with a service account token
+			token, _ = msgCtx.Value(api.MsgJwt).(*jwt.Token)
+			claimed  = len(api.HandledBy(msgCtx))
+			start    = time.Now()
+			handled  context.Context
+			err      error
+		)
+
+		if handled, err = h.Handle(msgCtx, token, body); handled != nil {
+			msgCtx = handled
+		}
+
+		if l.Report != nil {
+			l.Report(Result{
+				Handler: h,
+				Claimed: api.HandledBy(msgCtx)[claimed:],
+				Elapsed: time.Since(start),
+				Err:     err,
+			})
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(api.HandledBy(msgCtx)) == 0 {
+		return fmt.Errorf("%w: message [%s] from '%s'", UnhandledErr, l.MessageId, l.Destination)
+	}
+
+	return nil
+}
+
+// FileServer stands in for Drupal, so that a message can be handled using local files.  It serves Source to GET
+// requests for SourceUri, and writes the body of PUT requests for DestinationUri to Destination.  Either path may be
+// empty, in which case requests for the corresponding URI are answered with 404.
+type FileServer struct {
+	Source      string
+	Destination string
+
+	listener net.Listener
+	server   *http.Server
+
+	mu sync.Mutex
+	// contentType and written describe the last derivative written to Destination
+	contentType string
+	written     int64
+}
+
+// Start listens on an ephemeral port of the loopback interface, and serves requests in the background until Close is
+// invoked.
+func (s *FileServer) Start() error {
+	var err error
+	if s.listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return fmt.Errorf("local: unable to listen: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(sourcePath, s.serveSource)
+	mux.HandleFunc(destinationPath, s.receiveDestination)
+	s.server = &http.Server{Handler: mux}
+
+	go s.server.Serve(s.listener)
+	return nil
+}
+
+// SourceUri answers the URI from which Source is served.  The name of Source is retained, so handlers which rely on
+// the extension of the URI (e.g. FITS) behave as they would with a Drupal URI.
+func (s *FileServer) SourceUri() string {
+	return fmt.Sprintf("http://%s%s%s", s.listener.Addr(), sourcePath, filepath.Base(s.Source))
+}
+
+// DestinationUri answers the URI to which a derivative is PUT in order to write it to Destination
+func (s *FileServer) DestinationUri() string {
+	return fmt.Sprintf("http://%s%s", s.listener.Addr(), destinationPath)
+}
+
+// Written answers the Content-Type and size of the last derivative written to Destination; the size is zero if no
+// derivative has been written.
+func (s *FileServer) Written() (string, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.contentType, s.written
+}
+
+func (s *FileServer) Close() error {
+	return s.server.Close()
+}
+
+func (s *FileServer) serveSource(w http.ResponseWriter, r *http.Request) {
+	if s.Source == "" || r.Method != http.MethodGet || r.URL.Path != sourcePath+filepath.Base(s.Source) {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.ServeFile(w, r, s.Source)
+}
+
+func (s *FileServer) receiveDestination(w http.ResponseWriter, r *http.Request) {
+	if s.Destination == "" || r.Method != http.MethodPut {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Create(s.Destination)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.mu.Lock()
+	s.contentType, s.written = r.Header.Get("Content-Type"), n
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusCreated)
+}
@@ -0,0 +1,119 @@
+package local
+
+import (
+	"context"
+	"derivative-ms/api"
+	"errors"
+	"github.com/cristalhq/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// handlerFunc adapts a function to the api.Handler interface
+type handlerFunc func(ctx context.Context, t *jwt.Token, b *api.MessageBody) (context.Context, error)
+
+func (f handlerFunc) Handle(ctx context.Context, t *jwt.Token, b *api.MessageBody) (context.Context, error) {
+	return f(ctx, t, b)
+}
+
+func Test_Listen(t *testing.T) {
+	var results []Result
+	l := &Listener{
+		MessageId:   "msg-1",
+		Destination: "/queue/test",
+		Body:        []byte(`{"attachment": {"content": {"source_uri": "http://example.org/moo", "mimetype": "image/png"}}}`),
+		Report:      func(r Result) { results = append(results, r) },
+	}
+
+	passed := handlerFunc(func(ctx context.Context, token *jwt.Token, b *api.MessageBody) (context.Context, error) {
+		assert.Equal(t, "msg-1", ctx.Value(api.MsgId))
+		assert.Equal(t, "/queue/test", ctx.Value(api.MsgDestination))
+		assert.Equal(t, "http://example.org/moo", b.Attachment.Content.SourceUri)
+		assert.Nil(t, token)
+		return ctx, nil
+	})
+	claimed := handlerFunc(func(ctx context.Context, token *jwt.Token, b *api.MessageBody) (context.Context, error) {
+		return api.Handled(ctx, "moo"), nil
+	})
+
+	require.Nil(t, l.Listen(context.Background(), []api.Handler{passed, claimed}))
+	require.Len(t, results, 2)
+	assert.Empty(t, results[0].Claimed)
+	assert.Equal(t, []string{"moo"}, results[1].Claimed)
+}
+
+func Test_ListenFailure(t *testing.T) {
+	var results []Result
+	l := &Listener{Body: []byte(`{}`), Report: func(r Result) { results = append(results, r) }}
+
+	moo := errors.New("moo")
+	failed := handlerFunc(func(ctx context.Context, token *jwt.Token, b *api.MessageBody) (context.Context, error) {
+		return ctx, moo
+	})
+	notInvoked := handlerFunc(func(ctx context.Context, token *jwt.Token, b *api.MessageBody) (context.Context, error) {
+		t.Fatalf("handlers following a failed handler must not be invoked")
+		return ctx, nil
+	})
+
+	assert.ErrorIs(t, l.Listen(context.Background(), []api.Handler{failed, notInvoked}), moo)
+	require.Len(t, results, 1)
+	assert.ErrorIs(t, results[0].Err, moo)
+}
+
+func Test_ListenUnhandled(t *testing.T) {
+	l := &Listener{Body: []byte(`{}`)}
+	passed := handlerFunc(func(ctx context.Context, token *jwt.Token, b *api.MessageBody) (context.Context, error) {
+		return ctx, nil
+	})
+
+	assert.ErrorIs(t, l.Listen(context.Background(), []api.Handler{passed}), UnhandledErr)
+	assert.NotNil(t, l.Listen(context.Background(), nil))
+
+	l.Body = []byte(`moo`)
+	assert.NotNil(t, l.Listen(context.Background(), []api.Handler{passed}))
+}
+
+func Test_FileServer(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.tif")
+	require.Nil(t, ioutil.WriteFile(source, []byte("moo"), 0644))
+
+	s := &FileServer{Source: source, Destination: filepath.Join(dir, "out.png")}
+	require.Nil(t, s.Start())
+	defer s.Close()
+
+	assert.True(t, strings.HasSuffix(s.SourceUri(), "/source.tif"))
+
+	res, err := http.Get(s.SourceUri())
+	require.Nil(t, err)
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "moo", string(b))
+
+	// only the source file is served
+	res, err = http.Get(strings.TrimSuffix(s.SourceUri(), "source.tif") + "other.tif")
+	require.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	req, _ := http.NewRequest(http.MethodPut, s.DestinationUri(), strings.NewReader("derivative"))
+	req.Header.Set("Content-Type", "image/png")
+	res, err = http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+
+	b, err = ioutil.ReadFile(s.Destination)
+	require.Nil(t, err)
+	assert.Equal(t, "derivative", string(b))
+
+	contentType, size := s.Written()
+	assert.Equal(t, "image/png", contentType)
+	assert.Equal(t, int64(len("derivative")), size)
+}
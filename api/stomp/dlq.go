@@ -0,0 +1,353 @@
+package stomp
+
+import (
+	"context"
+	"derivative-ms/api"
+	"derivative-ms/logging"
+	"errors"
+	"fmt"
+	"github.com/cristalhq/jwt/v4"
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultDeadLetterQueue is the name of the ActiveMQ dead letter queue
+	DefaultDeadLetterQueue = "ActiveMQ.DLQ"
+
+	// ReplayToQueue sends dead letters back to their original destination
+	ReplayToQueue ReplayMode = "queue"
+	// ReplayLocal runs dead letters through the handler chain of this process
+	ReplayLocal ReplayMode = "local"
+
+	msgHeaderTimestamp = "timestamp"
+	// ActiveMQ queue browser support: a subscription with the browser header receives a copy of every message on the
+	// queue, followed by a message with the header 'browser: end'
+	subHeaderBrowser = "browser"
+	browserEnd       = "end"
+	// ActiveMQ prefetch size: the maximum number of unacknowledged messages delivered to a subscription
+	subHeaderPrefetch = "activemq.prefetchSize"
+	replayPrefetch    = "1000"
+	// JMS message selector: a subscription with the selector header only receives the messages matching the selector
+	subHeaderSelector = "selector"
+)
+
+// ReplayMode determines what is done with dead letters that are replayed
+type ReplayMode string
+
+// DeadLetter describes a message read from a dead letter queue
+type DeadLetter struct {
+	// MessageId is the broker-assigned id of the message
+	MessageId string
+	// OriginalDestination is the queue the message was sent to before it was moved to the dead letter queue
+	OriginalDestination string
+	// Timestamp is the time the message was originally sent, or the zero Time if unknown
+	Timestamp time.Time
+	// SourceUri is the source_uri of the message body, if the body could be parsed
+	SourceUri string
+	// TokenExpiry is the expiry of the JWT carried by the message, or the zero Time if there is no token or the token
+	// does not expire
+	TokenExpiry time.Time
+
+	message *stomp.Message
+}
+
+// DeadLetterFilter selects dead letters.  The zero value matches every dead letter.
+type DeadLetterFilter struct {
+	// Destination matches dead letters with the supplied original destination
+	Destination string
+	// MessageIds matches dead letters with one of the supplied message ids
+	MessageIds []string
+	// Since matches dead letters sent at or after the supplied time
+	Since time.Time
+	// Until matches dead letters sent before the supplied time
+	Until time.Time
+}
+
+// DeadLetterQueue lists and replays the messages on a dead letter queue
+type DeadLetterQueue struct {
+	Host       string
+	Port       int
+	User, Pass string
+	// Queue is the dead letter queue, DefaultDeadLetterQueue if empty
+	Queue string
+	// Wait is how long to wait for the next message before concluding the queue has been read
+	Wait time.Duration
+
+	conn *stomp.Conn
+}
+
+// Matches answers true if the dead letter satisfies every criterion of the filter
+func (f DeadLetterFilter) Matches(d DeadLetter) bool {
+	if f.Destination != "" && f.Destination != d.OriginalDestination {
+		return false
+	}
+
+	if len(f.MessageIds) > 0 {
+		found := false
+		for _, id := range f.MessageIds {
+			if id == d.MessageId {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if !f.Since.IsZero() && (d.Timestamp.IsZero() || d.Timestamp.Before(f.Since)) {
+		return false
+	}
+
+	if !f.Until.IsZero() && (d.Timestamp.IsZero() || !d.Timestamp.Before(f.Until)) {
+		return false
+	}
+
+	return true
+}
+
+// newDeadLetter describes m.  Malformed headers, bodies and tokens are tolerated: the corresponding fields of the
+// DeadLetter are left empty.
+func newDeadLetter(m *stomp.Message) DeadLetter {
+	d := DeadLetter{
+		MessageId:           m.Header.Get(msgHeaderMessageId),
+		OriginalDestination: m.Header.Get(msgHeaderOriginalDest),
+		message:             m,
+	}
+
+	if d.OriginalDestination == "" {
+		d.OriginalDestination = m.Header.Get(msgHeaderMessageDest)
+	}
+
+	if millis, err := strconv.ParseInt(m.Header.Get(msgHeaderTimestamp), 10, 64); err == nil && millis > 0 {
+		d.Timestamp = time.Unix(0, millis*int64(time.Millisecond))
+	}
+
+	if b, err := Body(m); err == nil {
+		d.SourceUri = b.Attachment.Content.SourceUri
+	}
+
+	if t, err := Jwt(m); err == nil && t != nil {
+		claims := jwt.RegisteredClaims{}
+		if err := t.DecodeClaims(&claims); err == nil && claims.ExpiresAt != nil {
+			d.TokenExpiry = claims.ExpiresAt.Time
+		}
+	}
+
+	return d
+}
+
+// Dial connects to the broker
+func (q *DeadLetterQueue) Dial(timeout time.Duration) error {
+	if q.Queue == "" {
+		q.Queue = DefaultDeadLetterQueue
+	}
+
+	c, err := dialWithTimeout(context.Background(), timeout, q.Host, q.Port, connOpts(q.Host, q.User, q.Pass)...)
+	if err != nil {
+		return err
+	}
+
+	q.conn = c
+	return nil
+}
+
+func (q *DeadLetterQueue) Close() error {
+	if q.conn != nil {
+		if err := q.conn.Disconnect(); err != nil {
+			return q.conn.MustDisconnect()
+		}
+	}
+
+	return nil
+}
+
+// Browse invokes fn for each dead letter matching the filter, without removing any messages from the queue
+func (q *DeadLetterQueue) Browse(filter DeadLetterFilter, fn func(d DeadLetter)) error {
+	sub, err := q.conn.Subscribe(q.Queue, stomp.AckAuto, stomp.SubscribeOpt.Header(subHeaderBrowser, "true"))
+	if err != nil {
+		return fmt.Errorf("stomp: unable to browse %s: %w", q.Queue, err)
+	}
+	defer sub.Unsubscribe()
+
+	return q.read(sub, func(m *stomp.Message) {
+		if d := newDeadLetter(m); filter.Matches(d) {
+			fn(d)
+		}
+	})
+}
+
+// Replay removes each dead letter matching the filter from the queue, and either sends it back to its original
+// destination or runs it through the supplied handlers, depending on mode.  Dead letters that do not match the filter
+// remain on the queue, as do dead letters that could not be replayed.  The result is the number of replayed messages.
+//
+// The dead letters matching the filter are found by browsing the queue, and only those dead letters are consumed, so
+// every matching dead letter is replayed however many other messages are on the queue.
+//
+// Each replayed dead letter is passed to fn with the error encountered replaying it, if any.
+func (q *DeadLetterQueue) Replay(ctx context.Context, filter DeadLetterFilter, mode ReplayMode, handlers []api.Handler, fn func(d DeadLetter, err error)) (int, error) {
+	if mode != ReplayToQueue && mode != ReplayLocal {
+		return 0, fmt.Errorf("stomp: unknown replay mode '%s'", mode)
+	}
+
+	// consuming the queue and skipping the dead letters that do not match would leave them unacked, and once the
+	// prefetch size is reached no further messages are delivered, so the matching dead letters behind them would be
+	// missed
+	var ids []string
+	if err := q.Browse(filter, func(d DeadLetter) {
+		if d.MessageId != "" {
+			ids = append(ids, d.MessageId)
+		}
+	}); err != nil {
+		return 0, err
+	}
+
+	if len(ids) == 0 || ctx.Err() != nil {
+		return 0, nil
+	}
+
+	sub, err := q.conn.Subscribe(q.Queue, stomp.AckClientIndividual,
+		stomp.SubscribeOpt.Header(subHeaderPrefetch, replayPrefetch),
+		stomp.SubscribeOpt.Header(subHeaderSelector, messageIdSelector(ids)))
+	if err != nil {
+		return 0, fmt.Errorf("stomp: unable to read %s: %w", q.Queue, err)
+	}
+	defer sub.Unsubscribe()
+
+	replayed := 0
+	err = q.read(sub, func(m *stomp.Message) {
+		d := newDeadLetter(m)
+		if ctx.Err() != nil {
+			// leave the message on the queue: it is redelivered to the queue when the connection is closed
+			return
+		}
+
+		var replayErr error
+		switch mode {
+		case ReplayToQueue:
+			replayErr = q.sendToOriginalDestination(d)
+		case ReplayLocal:
+			replayErr = q.handleLocally(ctx, d, handlers)
+		}
+
+		if replayErr == nil {
+			replayed++
+		}
+
+		fn(d, replayErr)
+	})
+
+	return replayed, err
+}
+
+// messageIdSelector answers a JMS message selector matching the messages with the supplied ids
+func messageIdSelector(ids []string) string {
+	quoted := make([]string, len(ids))
+	for i, id := range ids {
+		// a quote is escaped in a selector string literal by doubling it
+		quoted[i] = "'" + strings.ReplaceAll(id, "'", "''") + "'"
+	}
+
+	return fmt.Sprintf("JMSMessageID IN (%s)", strings.Join(quoted, ", "))
+}
+
+// sendToOriginalDestination sends a copy of the dead letter to its original destination, and acks the dead letter once
+// the broker has acknowledged receipt of the copy, so the dead letter is not lost if the copy is not accepted
+func (q *DeadLetterQueue) sendToOriginalDestination(d DeadLetter) error {
+	if d.OriginalDestination == "" {
+		return errors.New("stomp: dead letter has no original destination")
+	}
+
+	// copy application headers, but the original-destination header would be misleading on a message that is back
+	// on its original queue
+	var opts []func(*frame.Frame) error
+	for i := 0; i < d.message.Header.Len(); i++ {
+		k, v := d.message.Header.GetAt(i)
+		if _, skip := frameHeaders[k]; skip || k == msgHeaderOriginalDest {
+			continue
+		}
+		opts = append(opts, stomp.SendOpt.Header(k, v))
+	}
+	opts = append(opts, stomp.SendOpt.Receipt)
+
+	if err := q.conn.Send(d.OriginalDestination, d.message.ContentType, d.message.Body, opts...); err != nil {
+		return fmt.Errorf("stomp: unable to send message %s to %s: %w", d.MessageId, d.OriginalDestination, err)
+	}
+
+	return q.conn.Ack(d.message)
+}
+
+// handleLocally runs the dead letter through the handler chain as if it had been received from its original
+// destination.  The dead letter is acked if the chain succeeds; otherwise it is left on the queue.
+func (q *DeadLetterQueue) handleLocally(ctx context.Context, d DeadLetter, handlers []api.Handler) error {
+	// handlers respond to the original destination, not the dead letter queue
+	m := *d.message
+	m.Header = d.message.Header.Clone()
+	m.Header.Set(msgHeaderMessageDest, d.OriginalDestination)
+
+	conn := &replayConn{brokerConn: q.conn}
+	stompHandlers := []stompHandler{&messageIdHandler{}, &messageDestinationHandler{}, &jwtHandler{}, &bodyHandler{}, &messageLogger{}}
+	handleMessage(ctx, &ListenerImpl{}, conn, &m, stompHandlers, handlers)
+
+	if !conn.acked {
+		return fmt.Errorf("stomp: message %s was not handled successfully, leaving it on %s", d.MessageId, q.Queue)
+	}
+
+	return nil
+}
+
+// read invokes fn for each message received on the subscription, until q.Wait elapses without receiving a message or
+// the end of a queue browser is reached
+func (q *DeadLetterQueue) read(sub *stomp.Subscription, fn func(m *stomp.Message)) error {
+	timer := time.NewTimer(q.Wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			return nil
+		case m, ok := <-sub.C:
+			if !ok {
+				return nil
+			}
+			if m.Err != nil {
+				return fmt.Errorf("stomp: error reading %s: %w", q.Queue, m.Err)
+			}
+			if m.Header.Get(subHeaderBrowser) == browserEnd {
+				return nil
+			}
+
+			fn(m)
+
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(q.Wait)
+		}
+	}
+}
+
+// replayConn records whether a replayed message was acked.  Nacks are discarded, so that a message which could not be
+// replayed stays on the dead letter queue instead of being subject to the broker's redelivery policy.
+type replayConn struct {
+	brokerConn
+	acked bool
+}
+
+func (r *replayConn) Ack(m *stomp.Message) error {
+	if err := r.brokerConn.Ack(m); err != nil {
+		logging.Default().Error("stomp: error acking replayed message",
+			logging.FieldMessageId, m.Header.Get(msgHeaderMessageId), logging.FieldError, err)
+		return err
+	}
+	r.acked = true
+	return nil
+}
+
+func (r *replayConn) Nack(m *stomp.Message) error {
+	return nil
+}
@@ -0,0 +1,61 @@
+package stomp
+
+import (
+	"github.com/go-stomp/stomp/v3"
+	"github.com/go-stomp/stomp/v3/frame"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_NewDeadLetter(t *testing.T) {
+	m := &stomp.Message{
+		Header: frame.NewHeader(
+			msgHeaderMessageId, "ID:moo-1",
+			msgHeaderMessageDest, "/queue/ActiveMQ.DLQ",
+			msgHeaderOriginalDest, "/queue/islandora-connector-houdini",
+			msgHeaderTimestamp, "1636156800000"),
+		Body: []byte(`{"attachment": {"content": {"source_uri": "http://example.org/moo.jpg"}}}`),
+	}
+
+	d := newDeadLetter(m)
+	assert.Equal(t, "ID:moo-1", d.MessageId)
+	assert.Equal(t, "/queue/islandora-connector-houdini", d.OriginalDestination)
+	assert.Equal(t, "http://example.org/moo.jpg", d.SourceUri)
+	assert.True(t, time.Date(2021, 11, 6, 0, 0, 0, 0, time.UTC).Equal(d.Timestamp))
+	assert.True(t, d.TokenExpiry.IsZero())
+}
+
+func Test_NewDeadLetterMalformed(t *testing.T) {
+	m := &stomp.Message{
+		Header: frame.NewHeader(msgHeaderMessageId, "ID:moo-1", msgHeaderMessageDest, "/queue/moo", msgHeaderTimestamp, "moo"),
+		Body:   []byte(`moo`),
+	}
+
+	d := newDeadLetter(m)
+	assert.Equal(t, "/queue/moo", d.OriginalDestination)
+	assert.Equal(t, "", d.SourceUri)
+	assert.True(t, d.Timestamp.IsZero())
+}
+
+func Test_DeadLetterFilter(t *testing.T) {
+	sent := time.Date(2021, 11, 6, 0, 0, 0, 0, time.UTC)
+	d := DeadLetter{MessageId: "ID:moo-1", OriginalDestination: "/queue/moo", Timestamp: sent}
+
+	assert.True(t, DeadLetterFilter{}.Matches(d))
+	assert.True(t, DeadLetterFilter{Destination: "/queue/moo"}.Matches(d))
+	assert.False(t, DeadLetterFilter{Destination: "/queue/foo"}.Matches(d))
+	assert.True(t, DeadLetterFilter{MessageIds: []string{"ID:foo", "ID:moo-1"}}.Matches(d))
+	assert.False(t, DeadLetterFilter{MessageIds: []string{"ID:foo"}}.Matches(d))
+	assert.True(t, DeadLetterFilter{Since: sent}.Matches(d))
+	assert.False(t, DeadLetterFilter{Since: sent.Add(time.Second)}.Matches(d))
+	assert.True(t, DeadLetterFilter{Until: sent.Add(time.Second)}.Matches(d))
+	assert.False(t, DeadLetterFilter{Until: sent}.Matches(d))
+	assert.False(t, DeadLetterFilter{Since: sent}.Matches(DeadLetter{}), "messages without a timestamp cannot match a date filter")
+}
+
+func Test_MessageIdSelector(t *testing.T) {
+	assert.Equal(t, "JMSMessageID IN ('ID:moo-1')", messageIdSelector([]string{"ID:moo-1"}))
+	assert.Equal(t, "JMSMessageID IN ('ID:moo-1', 'ID:moo-2')", messageIdSelector([]string{"ID:moo-1", "ID:moo-2"}))
+	assert.Equal(t, "JMSMessageID IN ('ID:moo''s-1')", messageIdSelector([]string{"ID:moo's-1"}), "quotes are escaped")
+}
@@ -3,35 +3,133 @@ package stomp
 import (
 	"context"
 	"derivative-ms/api"
+	"derivative-ms/cmd"
+	"derivative-ms/drupal"
+	"derivative-ms/health"
+	"derivative-ms/jobs"
+	"derivative-ms/logging"
+	"derivative-ms/metrics"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/cristalhq/jwt/v4"
 	"github.com/go-stomp/stomp/v3"
-	"log"
+	"github.com/go-stomp/stomp/v3/frame"
+	"net"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
+	// maxBackoff caps the delay between successive attempts to dial the broker
+	maxBackoff = 30 * time.Second
+	// cancelGracePeriod is how long Listen waits for in-flight messages to be nacked after they are cancelled at
+	// shutdown
+	cancelGracePeriod = 5 * time.Second
+
 	msgHeaderMessageId   = "message-id"
 	msgHeaderMessageDest = "destination"
+	// msgHeaderOriginalDest records the destination of a message that has been moved to another queue.  ActiveMQ uses
+	// the same header for messages moved to the DLQ.
+	msgHeaderOriginalDest = "original-destination"
+)
+
+var (
+	messagesReceived = metrics.NewCounter("derivative_messages_received_total",
+		"Messages received from the broker", "destination")
+	messagesAcked = metrics.NewCounter("derivative_messages_acked_total",
+		"Messages acked, including unhandled messages sent to the unhandled queue", "destination")
+	messagesNacked = metrics.NewCounter("derivative_messages_nacked_total",
+		"Messages nacked", "destination")
 )
 
+// frameHeaders are set by the broker or the client library on each frame, and are not copied when a message is
+// forwarded to another destination
+var frameHeaders = map[string]struct{}{
+	frame.MessageId:     {},
+	frame.Destination:   {},
+	frame.Subscription:  {},
+	frame.Ack:           {},
+	frame.ContentLength: {},
+	frame.ContentType:   {},
+	frame.Receipt:       {},
+	"expires":           {},
+	"redelivered":       {},
+	"timestamp":         {},
+}
+
 type stompHandler interface {
 	handle(ctx context.Context, m *stomp.Message) (context.Context, error)
 }
 
+// brokerConn acks or nacks messages received from the broker, and sends messages to the broker; satisfied by
+// *stomp.Conn
+type brokerConn interface {
+	Ack(m *stomp.Message) error
+	Nack(m *stomp.Message) error
+	Send(destination, contentType string, body []byte, opts ...func(*frame.Frame) error) error
+}
+
 type ListenerImpl struct {
 	Host       string
 	Port       int
 	User, Pass string
 	Queue      string
 	AckMode    string
-	Debug      bool
+	// Workers is the maximum number of messages processed concurrently; values less than 1 are treated as 1
+	Workers int
+	// UnhandledQueue is the destination that messages not claimed by any handler are sent to.  If empty, unclaimed
+	// messages are nacked.
+	UnhandledQueue string
+	// ReconnectTimeout is the maximum amount of time spent re-establishing a lost connection and subscription to the
+	// broker before Listen gives up and returns an error
+	ReconnectTimeout time.Duration
+	// ShutdownTimeout is the maximum amount of time Listen waits for in-flight messages to complete after its context
+	// is cancelled.  Messages still in-flight after ShutdownTimeout are cancelled.
+	ShutdownTimeout time.Duration
+	// Monitor, if not nil, records the state of the subscription and the messages in flight
+	Monitor *health.Monitor
+	// Journal, if not nil, records the handling of recently received messages
+	Journal *jobs.Journal
 
 	conn *stomp.Conn
 	sub  *stomp.Subscription
+	pool *workerPool
+}
+
+// workerPool bounds the number of messages processed concurrently.  The pool outlives any single subscription, so
+// messages still in-flight when a connection is lost count against the pool after the subscription is re-established.
+//
+// Messages are handled using the pool's context, which is not cancelled when the listener's context is cancelled.  This
+// allows in-flight messages to complete during shutdown.  Cancelling the pool's context cancels all in-flight
+// messages.
+type workerPool struct {
+	sem    chan struct{}
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// detachedContext carries the values of its parent context, but is never cancelled and has no deadline
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (d detachedContext) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
 }
 
 var Jwt = func(message interface{}) (*jwt.Token, error) {
@@ -97,39 +195,50 @@ func (*bodyHandler) handle(ctx context.Context, m *stomp.Message) (context.Conte
 	}
 }
 
-func (*messageLogger) handle(ctx context.Context, m *stomp.Message) (context.Context, error) {
-	var err error
-	var prettyB []byte
-	b := map[string]interface{}{}
+// redacted replaces the value of a credential-bearing header when the headers of a message are logged
+const redacted = "[REDACTED]"
 
-	if err = json.Unmarshal(m.Body, &b); err != nil {
-		return ctx, err
+// credentialHeader answers true if the value of the header named k may carry a credential, e.g. the JWT in the
+// Authorization header of messages sent by Drupal
+func credentialHeader(k string) bool {
+	k = strings.ToLower(k)
+	switch k {
+	case "authorization", "proxy-authorization", "cookie", "passcode":
+		return true
 	}
-	if prettyB, err = json.MarshalIndent(b, "", "  "); err != nil {
-		return ctx, err
+	return strings.Contains(k, "token") || strings.Contains(k, "secret") || strings.Contains(k, "password")
+}
+
+// redactHeader answers the value of a credential-bearing header with its credential replaced, retaining the
+// authentication scheme (e.g. "Bearer [REDACTED]") so the kind of credential is still apparent
+func redactHeader(v string) string {
+	if i := strings.IndexByte(strings.TrimSpace(v), ' '); i > 0 {
+		return strings.TrimSpace(v)[:i] + " " + redacted
 	}
+	return redacted
+}
 
-	headers := strings.Builder{}
-	for i := 0; i < m.Header.Len(); i++ {
-		k, v := m.Header.GetAt(i)
-		headers.WriteString(fmt.Sprintf("    %s: %s\n", k, v))
+// handle adds the source URI of the message to the logger carried by ctx, and logs the headers and body of the
+// message at the debug level.  Credential-bearing headers are redacted.
+func (*messageLogger) handle(ctx context.Context, m *stomp.Message) (context.Context, error) {
+	logger := logging.FromContext(ctx)
+	if b, ok := ctx.Value(api.MsgBody).(*api.MessageBody); ok {
+		logger = logger.With(logging.FieldSourceUri, b.Attachment.Content.SourceUri)
 	}
 
-	log.Printf("[%s] [%s] STOMP headers and body\n"+
-		"  Content Type: %s\n"+
-		"  Destination: %s\n"+
-		"  Subscription: %s\n"+
-		"  Headers:\n%s"+
-		"  Body:\n"+
-		"%+v\n",
-		"StompLoggerHandler", m.Header.Get(msgHeaderMessageId),
-		m.ContentType,
-		m.Destination,
-		m.Subscription.Id(),
-		headers.String(),
-		string(prettyB))
+	if logger.Enabled(logging.LevelDebug) {
+		headers := map[string]string{}
+		for i := 0; i < m.Header.Len(); i++ {
+			k, v := m.Header.GetAt(i)
+			if credentialHeader(k) {
+				v = redactHeader(v)
+			}
+			headers[k] = v
+		}
+		logger.Debug("stomp: received message", "content_type", m.ContentType, "headers", headers, "body", string(m.Body))
+	}
 
-	return ctx, nil
+	return logging.WithLogger(ctx, logger), nil
 }
 
 func (jwtHandler) handle(ctx context.Context, m *stomp.Message) (context.Context, error) {
@@ -141,19 +250,56 @@ func (jwtHandler) handle(ctx context.Context, m *stomp.Message) (context.Context
 }
 
 func (l *ListenerImpl) Dial(host string, port int, timeout time.Duration) (api.Connection, error) {
-	stomp.ConnOpt.Host(host)
-	stomp.ConnOpt.Login(l.User, l.Pass)
-
-	if c, err := dialWithTimeout(timeout, host, port); err != nil {
+	if c, err := dialWithTimeout(context.Background(), timeout, host, port, connOpts(host, l.User, l.Pass)...); err != nil {
 		return nil, err
 	} else {
 		l.conn = c
 	}
 
-	log.Printf("stomp: successfully connected to %s:%d", host, port)
+	logging.Default().Info("stomp: connected to the broker", "host", host, "port", port)
 	return l, nil
 }
 
+// connOpts answers the options used to connect to the broker at host, logging in if user is not empty
+func connOpts(host, user, pass string) []func(*stomp.Conn) error {
+	opts := []func(*stomp.Conn) error{stomp.ConnOpt.Host(host)}
+	if user != "" {
+		opts = append(opts, stomp.ConnOpt.Login(user, pass))
+	}
+	return opts
+}
+
+// reconnect discards the current connection to the broker, and dials the broker and subscribes to l.Queue until it
+// succeeds, l.ReconnectTimeout elapses, or ctx is cancelled.
+func (l *ListenerImpl) reconnect(ctx context.Context) error {
+	var (
+		deadline = time.Now().Add(l.ReconnectTimeout)
+		err      error
+	)
+
+	if l.conn != nil {
+		l.conn.MustDisconnect()
+	}
+
+	for attempts := 0; time.Now().Before(deadline) && ctx.Err() == nil; attempts++ {
+		if l.conn, err = dialWithTimeout(ctx, time.Until(deadline), l.Host, l.Port, connOpts(l.Host, l.User, l.Pass)...); err != nil {
+			break
+		}
+
+		if err = l.Subscribe(l.Queue, api.AckMode(l.AckMode)); err == nil {
+			logging.Default().Info("stomp: re-subscribed", "queue", l.Queue, "host", l.Host, "port", l.Port)
+			return nil
+		}
+
+		logging.Default().Warn("stomp: unable to re-subscribe", "queue", l.Queue, logging.FieldError, err)
+		l.conn.MustDisconnect()
+		sleep(ctx, backoff(attempts, deadline))
+	}
+
+	return fmt.Errorf("stomp: unable to re-establish subscription to %s on %s:%d within %d seconds: %w",
+		l.Queue, l.Host, l.Port, int(l.ReconnectTimeout.Seconds()), err)
+}
+
 func (l *ListenerImpl) Close() error {
 	if l.conn != nil {
 		if err := l.conn.Disconnect(); err != nil {
@@ -181,6 +327,7 @@ func (l *ListenerImpl) Subscribe(queue string, ackMode api.AckMode) error {
 	}
 
 	l.sub = s
+	l.Monitor.Subscribed(true)
 
 	return nil
 }
@@ -192,73 +339,410 @@ func (l *ListenerImpl) Listen(ctx context.Context, handlers []api.Handler) error
 
 	var stompHandlers []stompHandler
 
-	if l.Debug {
-		stompHandlers = append(stompHandlers, &messageLogger{})
+	stompHandlers = append(stompHandlers, &messageIdHandler{}, &messageDestinationHandler{}, &jwtHandler{}, &bodyHandler{}, &messageLogger{})
+
+	for {
+		err := l.subscribe(ctx, stompHandlers, handlers)
+		l.Monitor.Subscribed(false)
+		if ctx.Err() != nil {
+			return l.shutdown(ctx)
+		}
+
+		if err == nil {
+			err = fmt.Errorf("stomp: subscription to %s was closed", l.Queue)
+		}
+
+		logging.Default().Warn("stomp: subscription lost, attempting to reconnect", logging.FieldError, err,
+			"reconnect_timeout", l.ReconnectTimeout)
+		if err = l.reconnect(ctx); err != nil {
+			if ctx.Err() != nil {
+				return l.shutdown(ctx)
+			}
+			return err
+		}
 	}
-	stompHandlers = append(stompHandlers, &messageIdHandler{}, &messageDestinationHandler{}, &jwtHandler{}, &bodyHandler{})
+}
+
+// subscribe handles messages from the current subscription until the subscription is closed.  If ctx is cancelled,
+// the subscription is unsubscribed, so the broker stops delivering messages to this listener.
+func (l *ListenerImpl) subscribe(ctx context.Context, stompHandlers []stompHandler, handlers []api.Handler) error {
+	var (
+		sub  = l.sub
+		done = make(chan struct{})
+	)
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			logging.Default().Info("stomp: shutting down, unsubscribing", "queue", l.Queue)
+			if err := sub.Unsubscribe(); err != nil {
+				logging.Default().Error("stomp: error unsubscribing", "queue", l.Queue, logging.FieldError, err)
+			}
+		case <-done:
+		}
+	}()
 
-	return doSubscribe(l, ctx, stompHandlers, handlers)
+	return doSubscribe(ctx, l, l.conn, sub.C, stompHandlers, handlers)
 }
 
-func doSubscribe(l *ListenerImpl, ctx context.Context, stompHandlers []stompHandler, handlers []api.Handler) (err error) {
+// shutdown waits up to l.ShutdownTimeout for in-flight messages to complete.  Messages still in-flight after the
+// timeout are cancelled, which kills any child processes they started, and are given a short grace period to be nacked.
+func (l *ListenerImpl) shutdown(ctx context.Context) error {
+	var (
+		pool    = l.workers(ctx)
+		drained = make(chan struct{})
+	)
 
-nextmsg:
-	for stompMsg := range l.sub.C {
+	go func() {
+		pool.wg.Wait()
+		close(drained)
+	}()
+
+	logging.Default().Info("stomp: waiting for in-flight messages to complete", "shutdown_timeout", l.ShutdownTimeout)
+	select {
+	case <-drained:
+		logging.Default().Info("stomp: in-flight messages completed")
+		return nil
+	case <-time.After(l.ShutdownTimeout):
+	}
 
-		// Run internal stomp message handlers first, so they can set the proper state on the context
-		for _, h := range stompHandlers {
-			if ctx, err = h.handle(ctx, stompMsg); err != nil {
-				log.Printf("stomp: internal error handling message [%s]: %s", stompMsg.Header.Get(msgHeaderMessageId), err)
-				if nackErr := l.conn.Nack(stompMsg); nackErr != nil {
-					log.Printf("stomp: internal error nacking message: %s: %s", err, stompMsg.Header.Get(msgHeaderMessageId))
-				}
-				continue nextmsg
-			}
+	logging.Default().Warn("stomp: shutdown timeout expired, cancelling in-flight messages")
+	pool.cancel()
+
+	select {
+	case <-drained:
+	case <-time.After(cancelGracePeriod):
+		logging.Default().Error("stomp: in-flight messages did not complete after being cancelled")
+	}
+
+	return nil
+}
+
+// workers answers the worker pool of the listener, creating it if necessary.  The context of a newly created pool
+// carries the values of ctx.
+func (l *ListenerImpl) workers(ctx context.Context) *workerPool {
+	if l.pool == nil {
+		size := l.Workers
+		if size < 1 {
+			size = 1
+		}
+		l.pool = &workerPool{sem: make(chan struct{}, size)}
+		l.pool.ctx, l.pool.cancel = context.WithCancel(detachedContext{ctx})
+	}
+	return l.pool
+}
+
+// doSubscribe reads messages until the messages channel is closed, handing each message to one of the available
+// workers.  When all workers are busy, no further messages are read until a worker becomes free.
+//
+// If the channel is closed because the subscription was unsubscribed, doSubscribe returns nil after all in-flight
+// messages have been acked or nacked.  If the connection to the broker is lost, doSubscribe returns an error
+// immediately, leaving in-flight messages to complete in the background.  Those messages cannot be acked on the lost
+// connection, and will be redelivered by the broker.
+//
+// If ctx is cancelled, doSubscribe stops handing messages to workers, nacks any messages delivered until the channel
+// is closed, and returns nil without waiting for in-flight messages.
+func doSubscribe(ctx context.Context, l *ListenerImpl, conn brokerConn, messages <-chan *stomp.Message, stompHandlers []stompHandler, handlers []api.Handler) error {
+	pool := l.workers(ctx)
+	conn = meteredConn{conn}
+
+	for {
+		var (
+			stompMsg *stomp.Message
+			ok       bool
+		)
+
+		select {
+		case <-ctx.Done():
+			nackUntilClosed(conn, messages)
+			return nil
+		case stompMsg, ok = <-messages:
+		}
+
+		if !ok {
+			pool.wg.Wait()
+			return nil
+		}
+
+		if stompMsg.Err != nil {
+			return fmt.Errorf("stomp: connection to the broker was lost: %w", stompMsg.Err)
+		}
+		messagesReceived.Inc(stompMsg.Header.Get(msgHeaderMessageDest))
+
+		select {
+		case <-ctx.Done():
+			conn.Nack(stompMsg)
+			nackUntilClosed(conn, messages)
+			return nil
+		case pool.sem <- struct{}{}:
 		}
 
-		body := ctx.Value(api.MsgBody)
-		token := ctx.Value(api.MsgJwt)
+		pool.wg.Add(1)
+		l.Monitor.Received()
+		go func(m *stomp.Message) {
+			defer func() {
+				l.Monitor.Completed()
+				<-pool.sem
+				pool.wg.Done()
+			}()
+			handleMessage(pool.ctx, l, conn, m, stompHandlers, handlers)
+		}(stompMsg)
+	}
+}
+
+// meteredConn counts the messages acked and nacked by their destination
+type meteredConn struct {
+	brokerConn
+}
+
+func (c meteredConn) Ack(m *stomp.Message) error {
+	err := c.brokerConn.Ack(m)
+	if err == nil {
+		messagesAcked.Inc(m.Header.Get(msgHeaderMessageDest))
+	}
+	return err
+}
+
+func (c meteredConn) Nack(m *stomp.Message) error {
+	err := c.brokerConn.Nack(m)
+	if err == nil {
+		messagesNacked.Inc(m.Header.Get(msgHeaderMessageDest))
+	}
+	return err
+}
 
-		// a body is required, the jwt may be optional
-		if body == nil {
-			l.conn.Nack(stompMsg)
-			continue nextmsg
+// nackUntilClosed returns messages delivered after shutdown has begun to the broker, until the messages channel is
+// closed by unsubscribing
+func nackUntilClosed(conn brokerConn, messages <-chan *stomp.Message) {
+	for m := range messages {
+		if m.Err == nil {
+			messagesReceived.Inc(m.Header.Get(msgHeaderMessageDest))
+			conn.Nack(m)
 		}
+	}
+}
 
-		// execute publicly configured handlers
-		for _, h := range handlers {
-			if ctx, err = h.Handle(ctx, token.(*jwt.Token), body.(*api.MessageBody)); err != nil {
-				log.Printf("stomp: error handling message [%s]: %s", stompMsg.Header.Get(msgHeaderMessageId), err)
-				if nackErr := l.conn.Nack(stompMsg); nackErr != nil {
-					log.Printf("stomp: error nacking message: %s: %s", err, stompMsg.Header.Get(msgHeaderMessageId))
-				}
-				continue nextmsg
+// handleMessage runs the internal and public handler chains for a single message, and acks or nacks the message
+// according to the outcome.  Each message is handled with its own context, derived from ctx, which carries a logger
+// identifying the message, and the job recording its handling in the journal of l.
+func handleMessage(ctx context.Context, l *ListenerImpl, conn brokerConn, stompMsg *stomp.Message, stompHandlers []stompHandler, handlers []api.Handler) {
+	var (
+		start  = time.Now()
+		logger = logging.FromContext(ctx).With(
+			logging.FieldMessageId, stompMsg.Header.Get(msgHeaderMessageId),
+			logging.FieldDestination, stompMsg.Header.Get(msgHeaderMessageDest))
+		job     = l.Journal.Begin(stompMsg.Header.Get(msgHeaderMessageId), stompMsg.Header.Get(msgHeaderMessageDest))
+		msgCtx  = jobs.WithJob(logging.WithLogger(ctx, logger), job)
+		outcome = jobs.OutcomeFailed
+		err     error
+	)
+
+	defer func() {
+		job.Finish(outcome, err)
+	}()
+
+	// Run internal stomp message handlers first, so they can set the proper state on the context
+	for _, h := range stompHandlers {
+		if msgCtx, err = h.handle(msgCtx, stompMsg); err != nil {
+			logger.Error("stomp: internal error handling message", logging.FieldError, err)
+			if nackErr := conn.Nack(stompMsg); nackErr != nil {
+				logger.Error("stomp: internal error nacking message", logging.FieldError, nackErr)
 			}
+			return
 		}
+	}
+
+	body := msgCtx.Value(api.MsgBody)
 
-		// TODO: what if no handler handled the message
+	// a body is required, the jwt may be optional
+	if body == nil {
+		conn.Nack(stompMsg)
+		return
+	}
 
-		if stompMsg.ShouldAck() {
-			l.conn.Ack(stompMsg)
+	// the logger of the message may have been given further fields by the internal handlers
+	logger = logging.FromContext(msgCtx)
+
+	// execute publicly configured handlers
+	for _, h := range handlers {
+		// the jwt may be absent, in which case handlers receive a nil token and apply their own policy.  It is read
+		// for each handler, because a handler may replace it, e.g. with a service account token.
+		token, _ := msgCtx.Value(api.MsgJwt).(*jwt.Token)
+		if msgCtx, err = h.Handle(msgCtx, token, body.(*api.MessageBody)); err != nil {
+			var (
+				statusErr *drupal.StatusError
+				failed    = logger.With(logging.FieldDurationMs, time.Since(start).Milliseconds(), logging.FieldError, err)
+			)
+			switch {
+			case errors.Is(err, api.TokenExpiredErr), errors.Is(err, api.TokenNotYetValidErr):
+				failed.Warn("stomp: rejecting message, its JWT is not valid at this time")
+			case errors.Is(err, api.TokenMissingErr), errors.Is(err, api.TokenInvalidErr),
+				errors.Is(err, api.TokenIssuerErr), errors.Is(err, api.TokenAudienceErr):
+				failed.Warn("stomp: rejecting message, its JWT is not acceptable")
+			case errors.Is(err, cmd.ArgsSyntaxErr), errors.Is(err, cmd.ArgsPolicyErr):
+				failed.Warn("stomp: rejecting message, its arguments are not acceptable")
+			case errors.Is(err, api.TimeoutErr):
+				failed.Error("stomp: error handling message, handler timed out")
+			case errors.As(err, &statusErr):
+				failed.Error("stomp: error handling message, Drupal responded with an error",
+					"method", statusErr.Method, "uri", statusErr.Uri, "status", statusErr.Code)
+			default:
+				failed.Error("stomp: error handling message")
+			}
+			if nackErr := conn.Nack(stompMsg); nackErr != nil {
+				logger.Error("stomp: error nacking message", logging.FieldError, nackErr)
+			}
+			return
 		}
 	}
 
-	return
+	logger = logger.With(logging.FieldDurationMs, time.Since(start).Milliseconds())
+
+	if len(api.HandledBy(msgCtx)) == 0 {
+		outcome = jobs.OutcomeUnhandled
+		handleUnclaimed(l, conn, stompMsg, logger)
+		return
+	}
+
+	outcome = jobs.OutcomeHandled
+
+	logger.Info("stomp: message handled", "handled_by", strings.Join(api.HandledBy(msgCtx), ","))
+	if stompMsg.ShouldAck() {
+		conn.Ack(stompMsg)
+	}
 }
 
-func dialWithTimeout(timeout time.Duration, host string, port int) (*stomp.Conn, error) {
-	var c *stomp.Conn
-	var err error
+// handleUnclaimed disposes of a message that was not claimed by any handler.  If an unhandled queue is configured, the
+// message is sent to that queue and acked, otherwise it is nacked.
+func handleUnclaimed(l *ListenerImpl, conn brokerConn, stompMsg *stomp.Message, logger *logging.Logger) {
+	if l.UnhandledQueue == "" {
+		logger.Warn("stomp: message was not handled by any handler")
+		if nackErr := conn.Nack(stompMsg); nackErr != nil {
+			logger.Error("stomp: error nacking unhandled message", logging.FieldError, nackErr)
+		}
+		return
+	}
 
-	deadline := time.Now().Add(timeout)
+	logger = logger.With("unhandled_queue", l.UnhandledQueue)
+	logger.Warn("stomp: message was not handled by any handler, sending it to the unhandled queue")
+	if err := conn.Send(l.UnhandledQueue, stompMsg.ContentType, stompMsg.Body, forwardHeaders(stompMsg)...); err != nil {
+		logger.Error("stomp: error sending unhandled message", logging.FieldError, err)
+		if nackErr := conn.Nack(stompMsg); nackErr != nil {
+			logger.Error("stomp: error nacking unhandled message", logging.FieldError, nackErr)
+		}
+		return
+	}
 
-	for attempts := 0; time.Now().Before(deadline); attempts++ {
-		if c, err = stomp.Dial("tcp", fmt.Sprintf("%s:%d", host, port)); err == nil {
-			return c, nil
+	if stompMsg.ShouldAck() {
+		conn.Ack(stompMsg)
+	}
+}
+
+// forwardHeaders answers send options which copy the application headers of stompMsg (e.g. Authorization) to a new
+// message, and record the destination stompMsg was originally sent to.
+func forwardHeaders(stompMsg *stomp.Message) []func(*frame.Frame) error {
+	var opts []func(*frame.Frame) error
+
+	for i := 0; i < stompMsg.Header.Len(); i++ {
+		k, v := stompMsg.Header.GetAt(i)
+		if _, skip := frameHeaders[k]; skip || k == msgHeaderOriginalDest {
+			continue
 		}
+		opts = append(opts, stomp.SendOpt.Header(k, v))
+	}
 
-		time.Sleep(time.Second << uint(attempts))
+	originalDest := stompMsg.Header.Get(msgHeaderOriginalDest)
+	if originalDest == "" {
+		originalDest = stompMsg.Header.Get(msgHeaderMessageDest)
+	}
+
+	return append(opts, stomp.SendOpt.Header(msgHeaderOriginalDest, originalDest))
+}
+
+func dialWithTimeout(ctx context.Context, timeout time.Duration, host string, port int, opts ...func(*stomp.Conn) error) (*stomp.Conn, error) {
+	var (
+		c      *stomp.Conn
+		err    error
+		dialer net.Dialer
+	)
+
+	deadline := time.Now().Add(timeout)
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	for attempts := 0; ctx.Err() == nil; attempts++ {
+		var netConn net.Conn
+		if netConn, err = dialer.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", host, port)); err == nil {
+			if c, err = handshake(ctx, netConn, deadline, opts...); err == nil {
+				return c, nil
+			}
+		}
+
+		sleep(ctx, backoff(attempts, deadline))
+	}
+
+	if err == nil {
+		err = ctx.Err()
 	}
 
 	return c, fmt.Errorf("stomp: timeout expired after %d seconds attempting to dial %s:%d; %w", int(timeout.Seconds()), host, port, err)
 }
+
+// handshake performs the STOMP connect protocol sequence over netConn, which is closed if the sequence fails.  The
+// sequence is abandoned when the deadline passes or ctx is done.
+func handshake(ctx context.Context, netConn net.Conn, deadline time.Time, opts ...func(*stomp.Conn) error) (*stomp.Conn, error) {
+	netConn.SetDeadline(deadline)
+
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			netConn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	c, err := stomp.Connect(netConn, opts...)
+	close(stop)
+	<-stopped
+
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	netConn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// sleep pauses for d, or until ctx is done
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// backoff answers the delay before the next dial attempt: exponential in the number of attempts already made, capped
+// by maxBackoff and by the time remaining until the deadline.
+func backoff(attempts int, deadline time.Time) time.Duration {
+	delay := maxBackoff
+	if attempts < 5 {
+		delay = time.Second << uint(attempts)
+	}
+
+	if remaining := time.Until(deadline); remaining < delay {
+		delay = remaining
+	}
+
+	if delay < 0 {
+		return 0
+	}
+
+	return delay
+}
@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	// ArgsSyntaxErr indicates that the arguments supplied by a message could not be tokenized, e.g. a quote is not
+	// terminated
+	ArgsSyntaxErr = errors.New("cmd: malformed arguments")
+	// ArgsPolicyErr indicates that the arguments supplied by a message contain an option that is not permitted
+	ArgsPolicyErr = errors.New("cmd: argument not permitted")
+
+	// schemePrefix matches a value beginning with a URI scheme or an ImageMagick coder, e.g. 'http:', 'msl:', or 'xc:',
+	// which commands may read as an input
+	schemePrefix = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*:`)
+	// fileExtension matches a value ending with a file extension, e.g. 'out.png'
+	fileExtension = regexp.MustCompile(`\.[A-Za-z][A-Za-z0-9]*$`)
+)
+
+// SplitArgs tokenizes s the way a POSIX shell splits words, without performing any expansion.  Tokens are separated by
+// unquoted whitespace.  Characters within single quotes are literal.  Within double quotes, a backslash escapes '"',
+// '\', '$', and '`', and is otherwise literal.  An unquoted backslash escapes the following character.  Quotes may
+// appear within a token, e.g. -annotate '0x0+10+10' or -metadata title="A Title".
+func SplitArgs(s string) ([]string, error) {
+	var (
+		args    []string
+		current strings.Builder
+		// inToken is true once a token has begun, so quoted empty strings are retained as tokens
+		inToken bool
+		runes   = []rune(s)
+	)
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			if inToken {
+				args = append(args, current.String())
+				current.Reset()
+				inToken = false
+			}
+
+		case r == '\\':
+			if i+1 >= len(runes) {
+				return nil, fmt.Errorf("%w: trailing backslash in '%s'", ArgsSyntaxErr, s)
+			}
+			i++
+			current.WriteRune(runes[i])
+			inToken = true
+
+		case r == '\'':
+			end := indexRune(runes, i+1, '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated single quote in '%s'", ArgsSyntaxErr, s)
+			}
+			current.WriteString(string(runes[i+1 : end]))
+			i = end
+			inToken = true
+
+		case r == '"':
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune("\"\\$`", runes[i+1]) {
+					i++
+				}
+				current.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("%w: unterminated double quote in '%s'", ArgsSyntaxErr, s)
+			}
+			inToken = true
+
+		default:
+			current.WriteRune(r)
+			inToken = true
+		}
+	}
+
+	if inToken {
+		args = append(args, current.String())
+	}
+
+	return args, nil
+}
+
+func indexRune(runes []rune, from int, r rune) int {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// ArgPolicy restricts the options that a message may supply as arguments to a command.  An option is an argument
+// beginning with '-' or '+' (as used by ImageMagick) followed by a letter, e.g. '-thumbnail' or '--preset=slow'; its
+// name excludes any '=value' suffix.  The number of values taken by each option is not known, so a value resembling an
+// option is treated as one.
+//
+// The zero value permits any option.  Whatever the options permitted, values which may refer to a file, and so add an
+// input or output to the command, are never permitted: see Check.
+type ArgPolicy struct {
+	// Allow lists the permitted options; if empty, any option not denied is permitted
+	Allow []string
+	// Deny lists the options that are not permitted, taking precedence over Allow
+	Deny []string
+}
+
+// Check tokenizes args using SplitArgs, answering an error wrapping ArgsSyntaxErr if it cannot be tokenized, or
+// ArgsPolicyErr if it contains an argument that is not permitted: an option that is not permitted, or a value
+// (including the '=value' suffix of an option) that may refer to a file because it contains a path separator, begins
+// with '@' or '~' or a scheme such as 'msl:', or ends with a file extension.
+func (p ArgPolicy) Check(args string) error {
+	tokens, err := SplitArgs(args)
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		name, ok := optionName(token)
+		if !ok {
+			if mayReferToFile(token) {
+				return fmt.Errorf("%w: argument '%s' may refer to a file", ArgsPolicyErr, token)
+			}
+			continue
+		}
+
+		if value := strings.TrimPrefix(token, name+"="); value != token && mayReferToFile(value) {
+			return fmt.Errorf("%w: value of option '%s' may refer to a file", ArgsPolicyErr, name)
+		}
+
+		if contains(p.Deny, name) {
+			return fmt.Errorf("%w: option '%s' is denied", ArgsPolicyErr, name)
+		}
+
+		if len(p.Allow) > 0 && !contains(p.Allow, name) {
+			return fmt.Errorf("%w: option '%s' is not allowed", ArgsPolicyErr, name)
+		}
+	}
+
+	return nil
+}
+
+// optionName answers the name of the option in token, and false if token is not an option
+func optionName(token string) (string, bool) {
+	trimmed := strings.TrimLeft(token, "-+")
+	if trimmed == token || trimmed == "" || !unicode.IsLetter([]rune(trimmed)[0]) {
+		return "", false
+	}
+
+	if i := strings.Index(token, "="); i > 0 {
+		return token[:i], true
+	}
+
+	return token, true
+}
+
+// mayReferToFile answers true if value could be read or written as a file by a command, e.g. '/etc/passwd',
+// '@list.txt', 'msl:moo', or 'out.png'
+func mayReferToFile(value string) bool {
+	return strings.ContainsAny(value, `/\`) ||
+		strings.HasPrefix(value, "@") ||
+		strings.HasPrefix(value, "~") ||
+		schemePrefix.MatchString(value) ||
+		fileExtension.MatchString(value)
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_SplitArgs(t *testing.T) {
+	for name, tc := range map[string]struct {
+		args     string
+		expected []string
+	}{
+		"Empty":               {"", nil},
+		"Whitespace":          {"  \t ", nil},
+		"Simple":              {"-thumbnail 100x100", []string{"-thumbnail", "100x100"}},
+		"RepeatedSpaces":      {"  -thumbnail   100x100 ", []string{"-thumbnail", "100x100"}},
+		"SingleQuotes":        {"-annotate '0x0+10+10' 'hello world'", []string{"-annotate", "0x0+10+10", "hello world"}},
+		"SingleQuotesLiteral": {`'a\b"c'`, []string{`a\b"c`}},
+		"DoubleQuotes":        {`-metadata title="A \"Moo\" Title"`, []string{"-metadata", `title=A "Moo" Title`}},
+		"DoubleQuotesLiteral": {`"a\b"`, []string{`a\b`}},
+		"Escaped":             {`hello\ world`, []string{"hello world"}},
+		"EmptyQuoted":         {`-comment ''`, []string{"-comment", ""}},
+		"Adjacent":            {`a'b'"c"`, []string{"abc"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			args, err := SplitArgs(tc.args)
+			require.Nil(t, err)
+			assert.Equal(t, tc.expected, args)
+		})
+	}
+
+	for _, malformed := range []string{`'moo`, `"moo`, `moo\`, `"moo\"`} {
+		_, err := SplitArgs(malformed)
+		assert.ErrorIs(t, err, ArgsSyntaxErr, "expected '%s' to be malformed", malformed)
+	}
+}
+
+func Test_ArgPolicy(t *testing.T) {
+	deny := ArgPolicy{Deny: []string{"-write", "+write"}}
+	assert.Nil(t, deny.Check("-thumbnail 100x100"))
+	assert.Nil(t, deny.Check("-rotate -90"), "negative numbers are not options")
+	assert.ErrorIs(t, deny.Check("-thumbnail 100x100 -write /tmp/moo.jpg"), ArgsPolicyErr)
+	assert.ErrorIs(t, deny.Check("+write /tmp/moo.jpg"), ArgsPolicyErr)
+	assert.ErrorIs(t, deny.Check("'-write' /tmp/moo.jpg"), ArgsPolicyErr, "quoting does not evade the policy")
+	assert.ErrorIs(t, deny.Check("-comment '-write'"), ArgsPolicyErr, "values resembling options are treated as options")
+
+	allow := ArgPolicy{Allow: []string{"-ss", "-frames", "-vf", "--preset"}, Deny: []string{"-vf"}}
+	assert.Nil(t, allow.Check("-ss 00:00:45.000 -frames 1"))
+	assert.Nil(t, allow.Check("--preset=slow"))
+	assert.ErrorIs(t, allow.Check("-ss 00:00:45.000 -i /etc/passwd"), ArgsPolicyErr)
+	assert.ErrorIs(t, allow.Check("-vf scale=100:-2"), ArgsPolicyErr, "deny takes precedence over allow")
+
+	assert.ErrorIs(t, ArgPolicy{}.Check("'moo"), ArgsSyntaxErr)
+	assert.Nil(t, ArgPolicy{}.Check("-thumbnail 100x100"), "the zero policy permits any option")
+	assert.ErrorIs(t, ArgPolicy{}.Check("-thumbnail 100x100 msl:moo"), ArgsPolicyErr, "the zero policy rejects file references")
+}
+
+func Test_ArgPolicyFiles(t *testing.T) {
+	deny := ArgPolicy{Deny: []string{"-write"}}
+	assert.Nil(t, deny.Check("-thumbnail 100x100 -quality 85 -resize 50%"))
+	assert.Nil(t, deny.Check("-annotate +10+10 'hello world' -rotate -90"))
+	assert.Nil(t, deny.Check("-ss 00:00:45.000 -vf scale=100:-2 -metadata title=\"A Title\""))
+
+	// values which may add an input or output to the command are rejected, whatever the options permitted
+	for _, args := range []string{
+		"/etc/passwd",
+		"-thumbnail 100x100 out.png",
+		"msl:moo",
+		"@list",
+		"~moo",
+		"-font ..\\moo",
+		"-comment http:example",
+		"--output=/tmp/moo",
+	} {
+		assert.ErrorIs(t, deny.Check(args), ArgsPolicyErr, args)
+	}
+}
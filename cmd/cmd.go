@@ -17,6 +17,9 @@ type ImageMagick struct {
 
 type FFMpeg struct {
 	AcceptedFormatsMap map[string]string
+	// Authorization answers the value of the Authorization header ffmpeg sends when retrieving the source, or the
+	// empty string if no header is sent.  If nil, the token is sent as a bearer token.
+	Authorization func(token *jwt.Token) string
 }
 
 type Tesseract struct {
@@ -25,16 +28,22 @@ type Tesseract struct {
 type Pdf2Text struct {
 }
 
+// FITS builds a command line for the File Information Tool Set, which reads the file named by the source URI of the
+// message body and writes FITS XML to stdout.  FITS cannot read from stdin or a URL, so the source URI of the message
+// body must be a path on the local filesystem.
+type FITS struct {
+}
+
 func (i ImageMagick) Build(commandPath string, token *jwt.Token, body *api.MessageBody) (*exec.Cmd, error) {
 	var cmdArgs []string
 	cmdArgs = append(cmdArgs, commandPath)
 	cmdArgs = append(cmdArgs, "-")
 	// "-thumbnail 100x100", or ""
-	if trimmedArgs := strings.TrimSpace(body.Attachment.Content.Args); len(trimmedArgs) > 0 {
-		for _, addlArg := range strings.Split(trimmedArgs, " ") {
-			cmdArgs = append(cmdArgs, addlArg)
-		}
+	addlArgs, err := SplitArgs(body.Attachment.Content.Args)
+	if err != nil {
+		return nil, err
 	}
+	cmdArgs = append(cmdArgs, addlArgs...)
 	convertFormat := body.Attachment.Content.MimeType[strings.LastIndex(body.Attachment.Content.MimeType, "/")+1:]
 	cmdArgs = append(cmdArgs, fmt.Sprintf("%s:-", convertFormat))
 	return &exec.Cmd{
@@ -66,13 +75,15 @@ func (f FFMpeg) Build(commandPath string, token *jwt.Token, body *api.MessageBod
 	var cmdArgs []string
 	cmdArgs = append(cmdArgs, commandPath)
 	//cmdArgs = append(cmdArgs, "-loglevel", "debug")
-	cmdArgs = append(cmdArgs, "-headers", fmt.Sprintf("Authorization: %s", asBearer(token)))
+	if authorization := f.authorization(token); authorization != "" {
+		cmdArgs = append(cmdArgs, "-headers", fmt.Sprintf("Authorization: %s", authorization))
+	}
 	cmdArgs = append(cmdArgs, "-i", body.Attachment.Content.SourceUri)
-	if trimmedArgs := strings.TrimSpace(body.Attachment.Content.Args); len(trimmedArgs) > 0 {
-		for _, addlArg := range strings.Split(trimmedArgs, " ") {
-			cmdArgs = append(cmdArgs, addlArg)
-		}
+	addlArgs, err := SplitArgs(body.Attachment.Content.Args)
+	if err != nil {
+		return nil, err
 	}
+	cmdArgs = append(cmdArgs, addlArgs...)
 	cmdArgs = append(cmdArgs, "-f", outputFormat)
 	cmdArgs = append(cmdArgs, "-")
 	return &exec.Cmd{
@@ -81,15 +92,27 @@ func (f FFMpeg) Build(commandPath string, token *jwt.Token, body *api.MessageBod
 	}, nil
 }
 
+func (f FFMpeg) authorization(token *jwt.Token) string {
+	if f.Authorization != nil {
+		return f.Authorization(token)
+	}
+
+	if token == nil {
+		return ""
+	}
+
+	return asBearer(token)
+}
+
 func (t Tesseract) Build(commandPath string, token *jwt.Token, body *api.MessageBody) (*exec.Cmd, error) {
 	var cmdArgs []string
 	cmdArgs = append(cmdArgs, commandPath)
 	cmdArgs = append(cmdArgs, "stdin", "stdout")
-	if trimmedArgs := strings.TrimSpace(body.Attachment.Content.Args); len(trimmedArgs) > 0 {
-		for _, addlArg := range strings.Split(trimmedArgs, " ") {
-			cmdArgs = append(cmdArgs, addlArg)
-		}
+	addlArgs, err := SplitArgs(body.Attachment.Content.Args)
+	if err != nil {
+		return nil, err
 	}
+	cmdArgs = append(cmdArgs, addlArgs...)
 	return &exec.Cmd{
 		Path: commandPath,
 		Args: cmdArgs,
@@ -99,11 +122,11 @@ func (t Tesseract) Build(commandPath string, token *jwt.Token, body *api.Message
 func (p Pdf2Text) Build(commandPath string, token *jwt.Token, body *api.MessageBody) (*exec.Cmd, error) {
 	var cmdArgs []string
 	cmdArgs = append(cmdArgs, commandPath)
-	if trimmedArgs := strings.TrimSpace(body.Attachment.Content.Args); len(trimmedArgs) > 0 {
-		for _, addlArg := range strings.Split(trimmedArgs, " ") {
-			cmdArgs = append(cmdArgs, addlArg)
-		}
+	addlArgs, err := SplitArgs(body.Attachment.Content.Args)
+	if err != nil {
+		return nil, err
 	}
+	cmdArgs = append(cmdArgs, addlArgs...)
 	cmdArgs = append(cmdArgs, "-", "-")
 	return &exec.Cmd{
 		Path: commandPath,
@@ -111,6 +134,25 @@ func (p Pdf2Text) Build(commandPath string, token *jwt.Token, body *api.MessageB
 	}, nil
 }
 
+func (f FITS) Build(commandPath string, token *jwt.Token, body *api.MessageBody) (*exec.Cmd, error) {
+	if body.Attachment.Content.SourceUri == "" {
+		return nil, fmt.Errorf("cmd: fits requires a local file as input")
+	}
+
+	var cmdArgs []string
+	cmdArgs = append(cmdArgs, commandPath)
+	cmdArgs = append(cmdArgs, "-i", body.Attachment.Content.SourceUri)
+	addlArgs, err := SplitArgs(body.Attachment.Content.Args)
+	if err != nil {
+		return nil, err
+	}
+	cmdArgs = append(cmdArgs, addlArgs...)
+	return &exec.Cmd{
+		Path: commandPath,
+		Args: cmdArgs,
+	}, nil
+}
+
 func asBearer(token *jwt.Token) string {
 	return fmt.Sprintf("Bearer %s", token)
 }
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"derivative-ms/api"
+	"fmt"
+	"github.com/cristalhq/jwt/v4"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+const (
+	// PlaceholderArgs is replaced by the arguments supplied by the message, tokenized by SplitArgs.  It must be an
+	// entire element of a Template, and may expand to any number of arguments.
+	PlaceholderArgs = "{args}"
+	// PlaceholderMimeType is replaced by the media type requested by the message, e.g. 'image/png'
+	PlaceholderMimeType = "{mimetype}"
+	// PlaceholderFormat is replaced by the subtype of the media type requested by the message, e.g. 'png'
+	PlaceholderFormat = "{format}"
+	// PlaceholderSource is replaced by the source of the message: '-' if the source is read from stdin, otherwise the
+	// source URI of the message body, e.g. the path of a local copy of the source
+	PlaceholderSource = "{source}"
+)
+
+// mediaType matches a media type without parameters, as restricted by RFC 6838, e.g. 'image/png' or 'image/svg+xml'
+var mediaType = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9!#$&^_.+-]{0,126}/[A-Za-z0-9][A-Za-z0-9!#$&^_.+-]{0,126}$`)
+
+// Template builds a command line from a template of arguments, in which placeholders are replaced by values of the
+// message.  For example, the template ["-", "{args}", "{format}:-"] reproduces the ImageMagick command line.  The
+// arguments supplied by the message are only used if the template contains PlaceholderArgs.
+//
+// The media type requested by the message is not checked by an ArgPolicy, so if the template contains
+// PlaceholderMimeType or PlaceholderFormat, Build answers an error wrapping ArgsPolicyErr unless the media type is well
+// formed, and its subtype may not refer to a file (e.g. 'msl:/etc/passwd' is rejected).
+type Template struct {
+	Args []string
+	// Stdin is true if the command reads the source from stdin
+	Stdin bool
+}
+
+func (t Template) Build(commandPath string, token *jwt.Token, body *api.MessageBody) (*exec.Cmd, error) {
+	var (
+		content = body.Attachment.Content
+		source  = content.SourceUri
+	)
+
+	if t.Stdin {
+		source = "-"
+	}
+
+	format := content.MimeType[strings.LastIndex(content.MimeType, "/")+1:]
+	if t.usesMediaType() && (!mediaType.MatchString(content.MimeType) || mayReferToFile(format)) {
+		return nil, fmt.Errorf("%w: media type '%s' is not permitted", ArgsPolicyErr, content.MimeType)
+	}
+
+	replacer := strings.NewReplacer(
+		PlaceholderMimeType, content.MimeType,
+		PlaceholderFormat, format,
+		PlaceholderSource, source,
+	)
+
+	cmdArgs := []string{commandPath}
+	for _, arg := range t.Args {
+		if arg == PlaceholderArgs {
+			addlArgs, err := SplitArgs(content.Args)
+			if err != nil {
+				return nil, err
+			}
+			cmdArgs = append(cmdArgs, addlArgs...)
+			continue
+		}
+
+		cmdArgs = append(cmdArgs, replacer.Replace(arg))
+	}
+
+	return &exec.Cmd{
+		Path: commandPath,
+		Args: cmdArgs,
+	}, nil
+}
+
+// usesMediaType answers true if the template contains PlaceholderMimeType or PlaceholderFormat
+func (t Template) usesMediaType() bool {
+	for _, arg := range t.Args {
+		if strings.Contains(arg, PlaceholderMimeType) || strings.Contains(arg, PlaceholderFormat) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"derivative-ms/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_Template(t *testing.T) {
+	b := &api.MessageBody{}
+	b.Attachment.Content.SourceUri = "/tmp/moo.tif"
+	b.Attachment.Content.MimeType = "image/png"
+	b.Attachment.Content.Args = "-thumbnail '100x100>'"
+
+	c, err := Template{Args: []string{"{source}", "{args}", "-define", "mime={mimetype}", "{format}:-"}}.Build("convert", nil, b)
+	require.Nil(t, err)
+	assert.Equal(t, "convert", c.Path)
+	assert.Equal(t, []string{"convert", "/tmp/moo.tif", "-thumbnail", "100x100>", "-define", "mime=image/png", "png:-"}, c.Args)
+
+	// the source is read from stdin
+	c, err = Template{Args: []string{"{source}", "{format}:-"}, Stdin: true}.Build("convert", nil, b)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"convert", "-", "png:-"}, c.Args)
+
+	// the arguments of the message are only used if the template includes them
+	c, err = Template{}.Build("exiftool", nil, b)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"exiftool"}, c.Args)
+
+	b.Attachment.Content.Args = "'moo"
+	_, err = Template{Args: []string{"{args}"}}.Build("convert", nil, b)
+	assert.ErrorIs(t, err, ArgsSyntaxErr)
+
+	// media types which are malformed or may refer to a file are rejected if the template uses them
+	for _, mimeType := range []string{"msl:/etc/passwd", "image/msl:moo", "image/moo.png", "image/@moo", "png"} {
+		b.Attachment.Content.MimeType = mimeType
+		_, err = Template{Args: []string{"{source}", "{format}:-"}}.Build("convert", nil, b)
+		assert.ErrorIs(t, err, ArgsPolicyErr, mimeType)
+		_, err = Template{Args: []string{"--type={mimetype}"}}.Build("convert", nil, b)
+		assert.ErrorIs(t, err, ArgsPolicyErr, mimeType)
+	}
+
+	b.Attachment.Content.MimeType = "image/svg+xml"
+	c, err = Template{Args: []string{"{format}:-"}}.Build("convert", nil, b)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"convert", "svg+xml:-"}, c.Args)
+
+	b.Attachment.Content.MimeType = "msl:/etc/passwd"
+	_, err = Template{Args: []string{"{source}"}}.Build("cat", nil, b)
+	assert.Nil(t, err, "the media type is not checked unless the template uses it")
+}
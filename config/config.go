@@ -9,6 +9,8 @@ import (
 	"log"
 	"math"
 	"os"
+	"path"
+	"time"
 )
 
 const (
@@ -23,6 +25,17 @@ const (
 	VarDrupalJwtPublicKey  = "DRUPAL_JWT_PUBLIC_KEY"
 	VarDrupalJwtPrivateKey = "DRUPAL_JWT_PRIVATE_KEY"
 
+	// VarReconnectTimeoutSeconds is the name of the environment variable containing the maximum number of seconds spent
+	// re-establishing a lost broker connection before the application exits
+	VarReconnectTimeoutSeconds = "DERIVATIVE_RECONNECT_TIMEOUT_SECONDS"
+	// VarShutdownTimeoutSeconds is the name of the environment variable containing the maximum number of seconds that
+	// in-flight messages are given to complete when the application is asked to shut down
+	VarShutdownTimeoutSeconds = "DERIVATIVE_SHUTDOWN_TIMEOUT_SECONDS"
+	// VarStallTimeoutSeconds is the name of the environment variable containing the maximum number of seconds that
+	// messages may be in flight without any progress before the application is no longer considered live
+	VarStallTimeoutSeconds = "DERIVATIVE_STALL_TIMEOUT_SECONDS"
+
+	FitsDestination      = "/queue/islandora-connector-fits"
 	HomarusDestination   = "/queue/islandora-connector-homarus"
 	HoudiniDestination   = "/queue/islandora-connector-houdini"
 	HypercubeDestination = "/queue/islandora-connector-ocr"
@@ -49,6 +62,11 @@ type Args struct {
 	AckMode       *string
 	CliConfigFile *string
 	Verbose       *bool
+	Workers       *int
+	Unhandled     *string
+	HttpAddr      *string
+	LogFormat     *string
+	DebugJobs     *int
 }
 
 // Config maintains the application configuration, including the configuration for each Handler.  The Resolve method
@@ -76,8 +94,15 @@ type Configuration struct {
 	Type string
 	// Order is the position relative to other Handlers in the application config by which the Handler is executed
 	Order int
+	// Timeout bounds the time the Handler may spend handling a message, unbounded if zero
+	Timeout time.Duration
 }
 
+// Destinations is a list of message destinations (i.e. STOMP queues) that a Handler responds to.  Each element is a
+// pattern as understood by path.Match, so a destination may be named exactly (e.g.
+// "/queue/islandora-connector-houdini") or with wildcards (e.g. "/queue/islandora-connector-*").
+type Destinations []string
+
 // Configurable accepts a Configuration instance and configures itself.  For example, a Handler may implement
 // Configurable, so it has an opportunity to set any runtime parameters before handling messages.
 type Configurable interface {
@@ -213,6 +238,87 @@ func MapValue(jsonBlob *map[string]interface{}, key string) (map[string]interfac
 	return (*jsonBlob)[key].(map[string]interface{}), nil
 }
 
+// IntValue returns a portion of the application Config as an int.
+//
+// The jsonBlob represents all or a portion of the application configuration expected to contain the provided top-level
+// key.  The result is the value represented by the key as an int.
+//
+// If the key is not found, a NotFoundErr will be returned.  If the keyed value cannot be converted to an int (e.g. it
+// is not a number, or it has a fractional part), a TypeConvErr is returned.
+func IntValue(jsonBlob *map[string]interface{}, key string) (int, error) {
+	if _, ok := (*jsonBlob)[key]; !ok {
+		return 0, notFoundErr(key)
+	}
+
+	switch v := (*jsonBlob)[key].(type) {
+	case int:
+		return v, nil
+	case float64:
+		// JSON numbers are unmarshaled as float64
+		if v == math.Trunc(v) && v >= math.MinInt32 && v <= math.MaxInt32 {
+			return int(v), nil
+		}
+	}
+
+	return 0, typeConversionErr(key, (*jsonBlob)[key], 0)
+}
+
+// DurationValue returns a portion of the application Config as a time.Duration.
+//
+// The jsonBlob represents all or a portion of the application configuration expected to contain the provided top-level
+// key.  The result is the value represented by the key, a string such as "30s" or "1m30s", as a time.Duration.
+//
+// If the key is not found, a NotFoundErr will be returned.  If the keyed value cannot be converted to a string, a
+// TypeConvErr is returned.  If the string is not a valid duration, a ParseErr is returned.
+func DurationValue(jsonBlob *map[string]interface{}, key string) (time.Duration, error) {
+	value, err := StringValue(jsonBlob, key)
+	if err != nil {
+		return 0, err
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("config: %w: invalid duration '%s' for configuration key '%s': %s", ParseErr, value, key, err)
+	}
+
+	return d, nil
+}
+
+// DestinationsValue returns a portion of the application Config as Destinations.
+//
+// The jsonBlob represents all or a portion of the application configuration which may contain the provided top-level
+// key.  If the key is not found, the defaults are returned.  If the keyed value cannot be converted to a []string, a
+// TypeConvErr is returned.  If any of the destinations is not a valid pattern, a ParseErr is returned.
+func DestinationsValue(jsonBlob *map[string]interface{}, key string, defaults ...string) (Destinations, error) {
+	if _, ok := (*jsonBlob)[key]; !ok {
+		return defaults, nil
+	}
+
+	patterns, err := SliceStringValue(jsonBlob, key)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("config: %w: invalid destination pattern '%s' for configuration key '%s': %s", ParseErr, pattern, key, err)
+		}
+	}
+
+	return patterns, nil
+}
+
+// Matches answers true if the destination matches any of the Destinations
+func (d Destinations) Matches(destination string) bool {
+	for _, pattern := range d {
+		if matched, _ := path.Match(pattern, destination); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
 // UnmarshalHandlerConfig unmarshals a Handler configuration from Config, based on the Configuration.Key.  If the key
 // is missing, or the configuration cannot be parsed, an error is returned.
 func (c *Configuration) UnmarshalHandlerConfig() (*map[string]interface{}, error) {
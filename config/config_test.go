@@ -4,6 +4,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
+	"time"
 )
 
 const (
@@ -219,3 +220,70 @@ func Test_SliceStringOkWithSliceInt(t *testing.T) {
 	_, err := SliceStringValue(&(map[string]interface{}{"moo": []int{10, 20}}), "moo")
 	assert.ErrorIs(t, err, TypeConvErr, "expected the key 'moo' to result in a TypeConvErr")
 }
+
+func Test_DestinationsValueDefault(t *testing.T) {
+	v, err := DestinationsValue(new(map[string]interface{}), "destinations", HoudiniDestination)
+	assert.Nil(t, err)
+	assert.Equal(t, Destinations{HoudiniDestination}, v)
+}
+
+func Test_DestinationsValueOk(t *testing.T) {
+	v, err := DestinationsValue(&(map[string]interface{}{"destinations": []interface{}{"/queue/moo", "/queue/foo-*"}}), "destinations", HoudiniDestination)
+	assert.Nil(t, err)
+	assert.Equal(t, Destinations{"/queue/moo", "/queue/foo-*"}, v)
+}
+
+func Test_DestinationsValueTypeConvErr(t *testing.T) {
+	_, err := DestinationsValue(&(map[string]interface{}{"destinations": "/queue/moo"}), "destinations")
+	assert.ErrorIs(t, err, TypeConvErr, "expected the key 'destinations' to result in a TypeConvErr")
+}
+
+func Test_DestinationsValueParseErr(t *testing.T) {
+	_, err := DestinationsValue(&(map[string]interface{}{"destinations": []interface{}{"/queue/[moo"}}), "destinations")
+	assert.ErrorIs(t, err, ParseErr, "expected the malformed pattern to result in a ParseErr")
+}
+
+func Test_DestinationsMatches(t *testing.T) {
+	d := Destinations{HoudiniDestination, "/queue/derivatives-*"}
+
+	assert.True(t, d.Matches(HoudiniDestination))
+	assert.True(t, d.Matches("/queue/derivatives-large"))
+	assert.False(t, d.Matches(HomarusDestination))
+	assert.False(t, d.Matches("/queue/derivatives/large"))
+	assert.False(t, Destinations{}.Matches(HoudiniDestination))
+}
+
+func Test_DurationValueOk(t *testing.T) {
+	v, err := DurationValue(&(map[string]interface{}{"leeway": "1m30s"}), "leeway")
+	assert.Nil(t, err)
+	assert.Equal(t, 90*time.Second, v)
+}
+
+func Test_DurationValueNotFoundErr(t *testing.T) {
+	_, err := DurationValue(new(map[string]interface{}), "leeway")
+	assert.ErrorIs(t, err, NotFoundErr, "expected the missing key 'leeway' to result in a NotFoundErr")
+}
+
+func Test_DurationValueParseErr(t *testing.T) {
+	_, err := DurationValue(&(map[string]interface{}{"leeway": "moo"}), "leeway")
+	assert.ErrorIs(t, err, ParseErr, "expected the malformed duration to result in a ParseErr")
+}
+
+func Test_IntValue(t *testing.T) {
+	v, err := IntValue(&(map[string]interface{}{"attempts": float64(3)}), "attempts")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, v)
+
+	v, err = IntValue(&(map[string]interface{}{"attempts": 4}), "attempts")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, v)
+
+	_, err = IntValue(&(map[string]interface{}{"attempts": 3.5}), "attempts")
+	assert.ErrorIs(t, err, TypeConvErr, "expected a fractional number to result in a TypeConvErr")
+
+	_, err = IntValue(&(map[string]interface{}{"attempts": "3"}), "attempts")
+	assert.ErrorIs(t, err, TypeConvErr, "expected a string to result in a TypeConvErr")
+
+	_, err = IntValue(new(map[string]interface{}), "attempts")
+	assert.ErrorIs(t, err, NotFoundErr, "expected the missing key 'attempts' to result in a NotFoundErr")
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"derivative-ms/api"
+	"derivative-ms/api/stomp"
+	"derivative-ms/config"
+	"derivative-ms/env"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	defaultDlqWait = 5
+
+	argDestination = "destination"
+	argMessageId   = "message-id"
+	argSince       = "since"
+	argUntil       = "until"
+	argReplay      = "replay"
+	argWait        = "wait"
+)
+
+// dlqMain implements the 'dlq' subcommand, which lists the messages on a dead letter queue, and optionally replays
+// them.
+func dlqMain(args []string) {
+	var (
+		flags       = flag.NewFlagSet(fmt.Sprintf("%s %s", os.Args[0], cmdDlq), flag.ExitOnError)
+		brokerHost  = flags.String(argBroker, defaultHost, "STOMP broker host name, e.g. 'islandora-idc.traefik.me'")
+		brokerPort  = flags.Int(argPort, defaultPort, "STOMP broker port")
+		queue       = flags.String(argQueue, stomp.DefaultDeadLetterQueue, "Dead letter queue to read messages from")
+		user        = flags.String(argUser, defaultUser, "STOMP broker user name")
+		pass        = flags.String(argPass, "", "STOMP broker password")
+		configFile  = flags.String(argConfig, "", "Path to handler configuration file, used when replaying messages locally")
+		destination = flags.String(argDestination, "", "Only select messages originally sent to this destination, e.g. '/queue/islandora-connector-houdini'")
+		messageIds  = flags.String(argMessageId, "", "Only select messages with these comma-separated message ids")
+		since       = flags.String(argSince, "", "Only select messages sent at or after this RFC 3339 time, e.g. '2021-11-01T00:00:00Z'")
+		until       = flags.String(argUntil, "", "Only select messages sent before this RFC 3339 time")
+		replay      = flags.String(argReplay, "", "Replay selected messages: 'queue' sends them back to their original destination, 'local' runs them through the handler chain of this process.  If empty, messages are only listed.")
+		wait        = flags.Int(argWait, defaultDlqWait, "Seconds to wait for the next message before concluding the queue has been read")
+		filter      = stomp.DeadLetterFilter{}
+		err         error
+	)
+	flags.Parse(args)
+
+	filter.Destination = *destination
+	if *messageIds != "" {
+		filter.MessageIds = strings.Split(*messageIds, ",")
+	}
+	if filter.Since, err = parseTime(argSince, *since); err != nil {
+		log.Fatalf("dlq: %s", err)
+	}
+	if filter.Until, err = parseTime(argUntil, *until); err != nil {
+		log.Fatalf("dlq: %s", err)
+	}
+
+	q := &stomp.DeadLetterQueue{
+		Host:  *brokerHost,
+		Port:  *brokerPort,
+		User:  *user,
+		Pass:  *pass,
+		Queue: *queue,
+		Wait:  time.Duration(*wait) * time.Second,
+	}
+
+	if err = q.Dial(time.Duration(env.GetIntOrDefault(config.VarDialTimeoutSeconds, defaultTimeout)) * time.Second); err != nil {
+		log.Fatalf("dlq: %s", err)
+	}
+	defer q.Close()
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer out.Flush()
+
+	if *replay == "" {
+		fmt.Fprintln(out, "MESSAGE ID\tORIGINAL DESTINATION\tTIMESTAMP\tSOURCE URI\tJWT EXPIRY")
+		err = q.Browse(filter, func(d stomp.DeadLetter) {
+			fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\n",
+				d.MessageId, d.OriginalDestination, formatTime(d.Timestamp), d.SourceUri, formatTime(d.TokenExpiry))
+		})
+		if err != nil {
+			log.Fatalf("dlq: %s", err)
+		}
+		return
+	}
+
+	var handlers []api.Handler
+	if stomp.ReplayMode(*replay) == stomp.ReplayLocal {
+		appConfig := &config.Config{}
+		appConfig.Resolve(*configFile)
+		handlers = configureHandlers(appConfig)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	fmt.Fprintln(out, "MESSAGE ID\tORIGINAL DESTINATION\tRESULT")
+	replayed, err := q.Replay(ctx, filter, stomp.ReplayMode(*replay), handlers, func(d stomp.DeadLetter, replayErr error) {
+		result := "replayed"
+		if replayErr != nil {
+			result = fmt.Sprintf("failed: %s", replayErr)
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s\n", d.MessageId, d.OriginalDestination, result)
+	})
+	if err != nil {
+		log.Fatalf("dlq: %s", err)
+	}
+
+	out.Flush()
+	log.Printf("dlq: replayed %d messages from %s", replayed, q.Queue)
+}
+
+// parseTime parses an RFC 3339 time supplied for the named argument, answering the zero Time if value is empty
+func parseTime(arg, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return t, fmt.Errorf("invalid value for -%s: %w", arg, err)
+	}
+
+	return t, nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.Format(time.RFC3339)
+}
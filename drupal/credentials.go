@@ -0,0 +1,84 @@
+package drupal
+
+import (
+	"derivative-ms/env"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/cristalhq/jwt/v4"
+	"io/ioutil"
+	"strings"
+)
+
+// Credentials authorize the requests made to Drupal on behalf of a message
+type Credentials interface {
+	// Authorization answers the value of the Authorization header for a request made on behalf of a message carrying
+	// the token, or the empty string if the request carries no credentials.  The token may be nil.
+	Authorization(token *jwt.Token) string
+}
+
+// JwtCredentials pass the JWT carried by the message through to Drupal.  Requests made on behalf of a message without
+// a token carry no credentials.
+type JwtCredentials struct{}
+
+// BasicAuthCredentials authenticate every request with HTTP Basic Auth, regardless of the token carried by the message
+type BasicAuthCredentials struct {
+	User, Pass string
+}
+
+// BearerCredentials authenticate every request with a static bearer token, regardless of the token carried by the
+// message
+type BearerCredentials struct {
+	Token string
+}
+
+// Secret locates a secret, such as a password.  The secret is the value of the environment variable Env, the content
+// of the file File, or the literal Value, in that order of precedence.  Empty locations are ignored.
+type Secret struct {
+	Value string
+	Env   string
+	File  string
+}
+
+func (JwtCredentials) Authorization(token *jwt.Token) string {
+	if token == nil {
+		return ""
+	}
+
+	return asBearer(token)
+}
+
+func (c BasicAuthCredentials) Authorization(token *jwt.Token) string {
+	return fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(c.User+":"+c.Pass)))
+}
+
+func (c BearerCredentials) Authorization(token *jwt.Token) string {
+	return fmt.Sprintf("Bearer %s", c.Token)
+}
+
+// Resolve answers the secret.  Trailing newlines are removed from secrets read from a file.
+func (s Secret) Resolve() (string, error) {
+	if s.Env != "" {
+		if value := env.GetOrDefault(s.Env, ""); value != "" {
+			return value, nil
+		}
+	}
+
+	if s.File != "" {
+		b, err := ioutil.ReadFile(s.File)
+		if err != nil {
+			return "", fmt.Errorf("drupal: unable to read secret: %w", err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+
+	if s.Value != "" {
+		return s.Value, nil
+	}
+
+	if s.Env != "" {
+		return "", fmt.Errorf("drupal: secret not found, environment variable %s is empty", s.Env)
+	}
+
+	return "", errors.New("drupal: secret not found")
+}
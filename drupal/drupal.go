@@ -1,29 +1,51 @@
 package drupal
 
 import (
+	"context"
 	"derivative-ms/drupal/request"
+	"derivative-ms/jobs"
+	"derivative-ms/metrics"
 	"fmt"
 	"github.com/cristalhq/jwt/v4"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"time"
 )
 
-var DefaultClient = &http.Client{}
+var (
+	requestDuration = metrics.NewHistogram("derivative_drupal_request_duration_seconds",
+		"Time taken by Drupal to respond to a request, until the response headers are received", metrics.LatencyBuckets, "method")
+	responses = metrics.NewCounter("derivative_drupal_responses_total",
+		"Responses to requests made to Drupal by status code, or 'error' if no response was received", "method", "code")
+	bytesTransferred = metrics.NewCounter("derivative_drupal_bytes_total",
+		"Bytes of request and response bodies streamed to ('out') and from ('in') Drupal", "direction")
+)
 
 type HttpImpl struct {
 	HttpClient *http.Client
+	// Credentials authorize requests, if nil the JWT carried by the message is used
+	Credentials Credentials
 }
 
 func (h HttpImpl) Put(reqCtx request.Context, uri string, body io.ReadCloser) (int, error) {
-	return put(h.HttpClient, uri, body, reqCtx)
+	return put(h.HttpClient, uri, body, h.authorization(reqCtx), reqCtx)
 }
 
 func (h HttpImpl) Get(reqCtx request.Context, uri string) (io.ReadCloser, error) {
-	return get(h.HttpClient, uri, reqCtx)
+	return get(h.HttpClient, uri, h.authorization(reqCtx), reqCtx)
 }
 
-func put(h *http.Client, uri string, body io.ReadCloser, reqCtx request.Context) (int, error) {
+func (h HttpImpl) authorization(reqCtx request.Context) string {
+	if h.Credentials == nil {
+		return JwtCredentials{}.Authorization(reqCtx.Token())
+	}
+
+	return h.Credentials.Authorization(reqCtx.Token())
+}
+
+func put(h *http.Client, uri string, body io.ReadCloser, authorization string, reqCtx request.Context) (int, error) {
 	var (
 		statusCode   int
 		statusMsg    string
@@ -31,7 +53,7 @@ func put(h *http.Client, uri string, body io.ReadCloser, reqCtx request.Context)
 		err          error
 	)
 
-	if responseBody, statusCode, statusMsg, err = doRequest(h, http.MethodPut, uri, reqCtx.Token(), body, reqCtx.Headers()); err != nil {
+	if responseBody, statusCode, statusMsg, err = doRequest(reqCtx.Context(), h, http.MethodPut, uri, authorization, body, reqCtx.Headers()); err != nil {
 		return statusCode, err
 	} else {
 		defer func() {
@@ -41,14 +63,13 @@ func put(h *http.Client, uri string, body io.ReadCloser, reqCtx request.Context)
 	}
 
 	if statusCode < 200 || statusCode >= 300 {
-		return statusCode, fmt.Errorf("drupal: error performing PUT %s: status code '%d', message: '%s'",
-			uri, statusCode, statusMsg)
+		return statusCode, newStatusError(http.MethodPut, uri, statusCode, statusMsg, responseBody)
 	}
 
 	return statusCode, nil
 }
 
-func get(h *http.Client, uri string, ctx request.Context) (io.ReadCloser, error) {
+func get(h *http.Client, uri string, authorization string, ctx request.Context) (io.ReadCloser, error) {
 	var (
 		statusCode   int
 		statusMsg    string
@@ -56,7 +77,7 @@ func get(h *http.Client, uri string, ctx request.Context) (io.ReadCloser, error)
 		err          error
 	)
 
-	if responseBody, statusCode, statusMsg, err = doRequest(h, http.MethodGet, uri, ctx.Token(), nil, ctx.Headers()); err != nil {
+	if responseBody, statusCode, statusMsg, err = doRequest(ctx.Context(), h, http.MethodGet, uri, authorization, nil, ctx.Headers()); err != nil {
 		return nil, err
 	}
 
@@ -65,37 +86,67 @@ func get(h *http.Client, uri string, ctx request.Context) (io.ReadCloser, error)
 			io.Copy(ioutil.Discard, responseBody)
 			responseBody.Close()
 		}()
-		return responseBody, fmt.Errorf("drupal: error performing GET %s: status code '%d', message: '%s'",
-			uri, statusCode, statusMsg)
+		return responseBody, newStatusError(http.MethodGet, uri, statusCode, statusMsg, responseBody)
 	}
 
 	return responseBody, nil
 }
 
-func doRequest(h *http.Client, method, uri string, authToken *jwt.Token, body io.ReadCloser, headers map[string]string) (responseBody io.ReadCloser, statusCode int, statusMessage string, err error) {
+func doRequest(ctx context.Context, h *http.Client, method, uri string, authorization string, body io.ReadCloser, headers map[string]string) (responseBody io.ReadCloser, statusCode int, statusMessage string, err error) {
 	var (
 		req *http.Request
 		res *http.Response
 	)
 
-	req, err = http.NewRequest(method, uri, body)
+	req, err = http.NewRequestWithContext(ctx, method, uri, body)
 	if err != nil {
 		return nil, -1, "", err
 	} else {
-		req.Close = true
-		if authToken != nil {
-			req.Header.Set("Authorization", asBearer(authToken))
+		if sized, ok := body.(*SpooledBody); ok {
+			// the body is sent with a Content-Length rather than chunked, and may be resent if Drupal redirects
+			req.ContentLength = sized.Size()
+			req.GetBody = sized.Replay
+			if sized.Size() == 0 {
+				req.Body = http.NoBody
+			}
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
 		}
 		for header, value := range headers {
 			req.Header.Set(header, value)
 		}
 	}
 
-	if res, err = h.Do(req); err != nil {
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = countingBody{req.Body, "out"}
+	}
+
+	start := time.Now()
+	res, err = h.Do(req)
+	elapsed := time.Since(start)
+	requestDuration.Observe(elapsed.Seconds(), method)
+	if err != nil {
+		responses.Inc(method, "error")
+		jobs.FromContext(ctx).Request(jobs.Request{Method: method, Uri: uri, Code: -1, Elapsed: elapsed, Error: err.Error()})
 		return nil, -1, "", err
 	}
+	responses.Inc(method, strconv.Itoa(res.StatusCode))
+	jobs.FromContext(ctx).Request(jobs.Request{Method: method, Uri: uri, Code: res.StatusCode, Elapsed: elapsed})
+
+	return countingBody{res.Body, "in"}, res.StatusCode, res.Status, nil
+}
+
+// countingBody counts the bytes read from a request or response body in bytesTransferred
+type countingBody struct {
+	io.ReadCloser
+	direction string
+}
 
-	return res.Body, res.StatusCode, res.Status, nil
+func (b countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	bytesTransferred.Add(float64(n), b.direction)
+	return n, err
 }
 
 func asBearer(token *jwt.Token) string {
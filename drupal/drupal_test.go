@@ -0,0 +1,127 @@
+package drupal
+
+import (
+	"derivative-ms/drupal/request"
+	"github.com/cristalhq/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// authorizationServer answers a server recording the Authorization header of the last request it received
+func authorizationServer(header *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*header = r.Header.Get("Authorization")
+	}))
+}
+
+func Test_Credentials(t *testing.T) {
+	signer, err := jwt.NewSignerHS(jwt.HS256, []byte("moo"))
+	require.Nil(t, err)
+	token, err := jwt.NewBuilder(signer).Build(jwt.RegisteredClaims{Subject: "moo"})
+	require.Nil(t, err)
+
+	for name, tc := range map[string]struct {
+		credentials Credentials
+		token       *jwt.Token
+		expected    string
+	}{
+		"Default":      {nil, token, "Bearer " + token.String()},
+		"DefaultNoJwt": {nil, nil, ""},
+		"Jwt":          {JwtCredentials{}, token, "Bearer " + token.String()},
+		"Basic":        {BasicAuthCredentials{User: "moo", Pass: "foo"}, token, "Basic bW9vOmZvbw=="},
+		"Bearer":       {BearerCredentials{Token: "moo"}, token, "Bearer moo"},
+		"BearerNoJwt":  {BearerCredentials{Token: "moo"}, nil, "Bearer moo"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var header string
+			server := authorizationServer(&header)
+			defer server.Close()
+
+			client := HttpImpl{HttpClient: server.Client(), Credentials: tc.credentials}
+			body, err := client.Get(*request.New().WithToken(tc.token), server.URL)
+			require.Nil(t, err)
+			body.Close()
+			assert.Equal(t, tc.expected, header)
+
+			header = ""
+			_, err = client.Put(*request.New().WithToken(tc.token), server.URL, ioutil.NopCloser(strings.NewReader("")))
+			require.Nil(t, err)
+			assert.Equal(t, tc.expected, header)
+		})
+	}
+}
+
+func Test_SecretResolve(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "secret")
+	require.Nil(t, ioutil.WriteFile(file, []byte("from-file\n"), 0600))
+	t.Setenv("DRUPAL_TEST_SECRET", "from-env")
+	t.Setenv("DRUPAL_TEST_EMPTY", "")
+
+	for name, tc := range map[string]struct {
+		secret   Secret
+		expected string
+	}{
+		"Env":      {Secret{Value: "moo", Env: "DRUPAL_TEST_SECRET", File: file}, "from-env"},
+		"EmptyEnv": {Secret{Value: "moo", Env: "DRUPAL_TEST_EMPTY", File: file}, "from-file"},
+		"File":     {Secret{Value: "moo", File: file}, "from-file"},
+		"Value":    {Secret{Value: "moo", Env: "DRUPAL_TEST_EMPTY"}, "moo"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			value, err := tc.secret.Resolve()
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, value)
+		})
+	}
+
+	_, err := Secret{Env: "DRUPAL_TEST_EMPTY"}.Resolve()
+	assert.NotNil(t, err)
+	_, err = Secret{File: filepath.Join(t.TempDir(), "moo")}.Resolve()
+	assert.NotNil(t, err)
+}
+
+func Test_RequestMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte("moo"))
+	}))
+	defer server.Close()
+
+	var (
+		d           = HttpImpl{HttpClient: server.Client()}
+		gets, puts  = requestDuration.Count(http.MethodGet), requestDuration.Count(http.MethodPut)
+		ok, created = responses.Value(http.MethodGet, "200"), responses.Value(http.MethodPut, "204")
+		in, out     = bytesTransferred.Value("in"), bytesTransferred.Value("out")
+	)
+
+	body, err := d.Get(*request.New(), server.URL)
+	require.Nil(t, err)
+	ioutil.ReadAll(body)
+	body.Close()
+
+	_, err = d.Put(*request.New(), server.URL, ioutil.NopCloser(strings.NewReader("foobar")))
+	require.Nil(t, err)
+
+	assert.Equal(t, gets+1, requestDuration.Count(http.MethodGet))
+	assert.Equal(t, puts+1, requestDuration.Count(http.MethodPut))
+	assert.Equal(t, ok+1, responses.Value(http.MethodGet, "200"))
+	assert.Equal(t, created+1, responses.Value(http.MethodPut, "204"))
+	assert.Equal(t, in+3, bytesTransferred.Value("in"))
+	assert.Equal(t, out+6, bytesTransferred.Value("out"))
+
+	// no response is received from a server that is not listening
+	errors := responses.Value(http.MethodGet, "error")
+	server.Close()
+	_, err = d.Get(*request.New(), server.URL)
+	assert.NotNil(t, err)
+	assert.Equal(t, errors+1, responses.Value(http.MethodGet, "error"))
+}
@@ -0,0 +1,65 @@
+package drupal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// maxExcerpt is the maximum number of bytes of a response body kept by a StatusError
+const maxExcerpt = 512
+
+// StatusError is returned when Drupal responds to a request with a status code other than 2xx
+type StatusError struct {
+	Method string
+	Uri    string
+	// Code is the status code of the response, e.g. 503
+	Code int
+	// Status is the status line of the response, e.g. "503 Service Unavailable"
+	Status string
+	// Body is an excerpt of the response body
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("drupal: error performing %s %s: status code '%d', message: '%s', body: '%s'",
+		e.Method, e.Uri, e.Code, e.Status, e.Body)
+}
+
+// newStatusError answers a StatusError, reading an excerpt of the response body
+func newStatusError(method, uri string, code int, status string, body io.Reader) *StatusError {
+	excerpt, _ := ioutil.ReadAll(io.LimitReader(body, maxExcerpt))
+	return &StatusError{
+		Method: method,
+		Uri:    uri,
+		Code:   code,
+		Status: status,
+		Body:   strings.TrimSpace(string(excerpt)),
+	}
+}
+
+// Retryable answers true if a request that failed with err may succeed if it is made again: Drupal responded with a
+// 5xx status code, or the request could not be completed, e.g. the connection was refused or reset, or timed out.
+// Requests that Drupal rejected with a 4xx status code, that are malformed, or that were cancelled are not retryable.
+func Retryable(err error) bool {
+	var (
+		statusErr *StatusError
+		urlErr    *url.Error
+	)
+
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= 500
+	}
+
+	// errors performing the request are *url.Errors, excepting errors parsing the url.  The timeouts of the
+	// http.Client are reported as *url.Errors, and are retryable.
+	return errors.As(err, &urlErr) && urlErr.Op != "parse"
+}
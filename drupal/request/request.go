@@ -1,8 +1,12 @@
 package request
 
-import "github.com/cristalhq/jwt/v4"
+import (
+	"context"
+	"github.com/cristalhq/jwt/v4"
+)
 
 type Context struct {
+	ctx     context.Context
 	token   *jwt.Token
 	headers map[string]string
 }
@@ -23,6 +27,12 @@ func (rc *Context) WithToken(token *jwt.Token) *Context {
 	return rc
 }
 
+// WithContext sets the context of the request, which cancels the request when it is done
+func (rc *Context) WithContext(ctx context.Context) *Context {
+	rc.ctx = ctx
+	return rc
+}
+
 func (rc *Context) Headers() map[string]string {
 	if rc.headers == nil {
 		return make(map[string]string, 1)
@@ -34,3 +44,12 @@ func (rc *Context) Headers() map[string]string {
 func (rc *Context) Token() *jwt.Token {
 	return rc.token
 }
+
+// Context answers the context of the request, context.Background() if none was set
+func (rc *Context) Context() context.Context {
+	if rc.ctx == nil {
+		return context.Background()
+	}
+
+	return rc.ctx
+}
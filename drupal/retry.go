@@ -0,0 +1,119 @@
+package drupal
+
+import (
+	"context"
+	"derivative-ms/drupal/request"
+	"derivative-ms/logging"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+)
+
+const (
+	DefaultAttempts       = 3
+	DefaultInitialBackoff = 500 * time.Millisecond
+	DefaultMaxBackoff     = 10 * time.Second
+)
+
+// ReplayableBody is a request body that can be read more than once, allowing a PUT that failed to be retried
+type ReplayableBody interface {
+	io.ReadCloser
+	// Replay answers a reader of the entire body, from its beginning
+	Replay() (io.ReadCloser, error)
+}
+
+// RetryingClient retries requests made by Client that fail with a Retryable error, sleeping for an exponentially
+// increasing, jittered, backoff between attempts.  GETs are always retried; PUTs are only retried if their body is a
+// ReplayableBody, because a streamed body cannot be sent again.  Retries are abandoned once the context of the request
+// is done.
+type RetryingClient struct {
+	Client Client
+	// Attempts is the maximum number of attempts made for each request, DefaultAttempts if zero
+	Attempts int
+	// InitialBackoff is the backoff after the first attempt, DefaultInitialBackoff if zero
+	InitialBackoff time.Duration
+	// MaxBackoff caps the backoff between attempts, DefaultMaxBackoff if zero
+	MaxBackoff time.Duration
+}
+
+func (r RetryingClient) Get(reqCtx request.Context, uri string) (io.ReadCloser, error) {
+	for attempt := 1; ; attempt++ {
+		body, err := r.Client.Get(reqCtx, uri)
+		if err == nil || !Retryable(err) || attempt >= r.attempts() {
+			return body, err
+		}
+
+		if err = r.wait(reqCtx.Context(), "GET", uri, attempt, err); err != nil {
+			return body, err
+		}
+	}
+}
+
+func (r RetryingClient) Put(reqCtx request.Context, uri string, body io.ReadCloser) (int, error) {
+	replayable, canReplay := body.(ReplayableBody)
+
+	for attempt := 1; ; attempt++ {
+		code, err := r.Client.Put(reqCtx, uri, body)
+		if err == nil || !canReplay || !Retryable(err) || attempt >= r.attempts() {
+			return code, err
+		}
+
+		if err = r.wait(reqCtx.Context(), "PUT", uri, attempt, err); err != nil {
+			return code, err
+		}
+
+		if body, err = replayable.Replay(); err != nil {
+			return code, fmt.Errorf("drupal: unable to retry PUT %s: %w", uri, err)
+		}
+	}
+}
+
+func (r RetryingClient) attempts() int {
+	if r.Attempts < 1 {
+		return DefaultAttempts
+	}
+	return r.Attempts
+}
+
+// wait sleeps before the attempt following the supplied attempt, answering an error if ctx is done before then
+func (r RetryingClient) wait(ctx context.Context, method, uri string, attempt int, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("drupal: abandoned %s %s after attempt %d: %w", method, uri, attempt, ctx.Err())
+	}
+
+	d := r.backoff(attempt)
+	logging.FromContext(ctx).Warn("drupal: request failed, retrying", "method", method, "uri", uri,
+		"attempt", attempt, "attempts", r.attempts(), "backoff", d, logging.FieldError, err)
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("drupal: abandoned %s %s after attempt %d: %w", method, uri, attempt, ctx.Err())
+	}
+}
+
+// backoff answers the time to wait after the supplied attempt: the initial backoff doubled for every attempt after the
+// first, capped by the maximum backoff, of which a random half is waited
+func (r RetryingClient) backoff(attempt int) time.Duration {
+	initial, max := r.InitialBackoff, r.MaxBackoff
+	if initial == 0 {
+		initial = DefaultInitialBackoff
+	}
+	if max == 0 {
+		max = DefaultMaxBackoff
+	}
+
+	d := max
+	if attempt <= 32 {
+		if exp := initial << uint(attempt-1); exp > 0 && exp < max {
+			d = exp
+		}
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
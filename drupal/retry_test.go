@@ -0,0 +1,176 @@
+package drupal
+
+import (
+	"bytes"
+	"context"
+	"derivative-ms/drupal/request"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// replayableBytes is a ReplayableBody over a byte slice
+type replayableBytes struct {
+	io.Reader
+	b []byte
+}
+
+func newReplayableBytes(b []byte) *replayableBytes {
+	return &replayableBytes{Reader: bytes.NewReader(b), b: b}
+}
+
+func (r *replayableBytes) Close() error {
+	return nil
+}
+
+func (r *replayableBytes) Replay() (io.ReadCloser, error) {
+	return newReplayableBytes(r.b), nil
+}
+
+// statusServer answers the supplied status codes in order, answering 200 once they are exhausted, and records the
+// bodies of the requests it receives
+type statusServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	codes  []int
+	bodies []string
+}
+
+func newStatusServer(codes ...int) *statusServer {
+	s := &statusServer{codes: codes}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		b, _ := ioutil.ReadAll(r.Body)
+		s.bodies = append(s.bodies, string(b))
+		code := http.StatusOK
+		if len(s.codes) > 0 {
+			code, s.codes = s.codes[0], s.codes[1:]
+		}
+		w.WriteHeader(code)
+		io.WriteString(w, http.StatusText(code))
+	}))
+	return s
+}
+
+func newRetryingClient(s *statusServer) RetryingClient {
+	return RetryingClient{
+		Client:         HttpImpl{HttpClient: s.Client()},
+		Attempts:       3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     2 * time.Millisecond,
+	}
+}
+
+func Test_RetryingGet(t *testing.T) {
+	s := newStatusServer(http.StatusServiceUnavailable, http.StatusBadGateway)
+	defer s.Close()
+
+	body, err := newRetryingClient(s).Get(*request.New(), s.URL)
+	require.Nil(t, err)
+	b, _ := ioutil.ReadAll(body)
+	assert.Equal(t, "OK", string(b))
+	assert.Len(t, s.bodies, 3)
+}
+
+func Test_RetryingGetExhausted(t *testing.T) {
+	s := newStatusServer(http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	defer s.Close()
+
+	_, err := newRetryingClient(s).Get(*request.New(), s.URL)
+	var statusErr *StatusError
+	require.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusServiceUnavailable, statusErr.Code)
+	assert.Equal(t, "Service Unavailable", statusErr.Body)
+	assert.Equal(t, http.MethodGet, statusErr.Method)
+	assert.Len(t, s.bodies, 3)
+}
+
+func Test_RetryingGetClientError(t *testing.T) {
+	s := newStatusServer(http.StatusNotFound)
+	defer s.Close()
+
+	_, err := newRetryingClient(s).Get(*request.New(), s.URL)
+	var statusErr *StatusError
+	require.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusNotFound, statusErr.Code)
+	assert.Len(t, s.bodies, 1, "4xx responses must not be retried")
+}
+
+func Test_RetryingGetConnectionError(t *testing.T) {
+	s := newStatusServer()
+	client := newRetryingClient(s)
+	s.Close()
+
+	_, err := client.Get(*request.New(), s.URL)
+	assert.NotNil(t, err)
+	assert.True(t, Retryable(err), "expected a refused connection to be retryable")
+}
+
+func Test_RetryingPutReplayable(t *testing.T) {
+	s := newStatusServer(http.StatusInternalServerError)
+	defer s.Close()
+
+	code, err := newRetryingClient(s).Put(*request.New(), s.URL, newReplayableBytes([]byte("moo")))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"moo", "moo"}, s.bodies)
+}
+
+func Test_RetryingPutStreamed(t *testing.T) {
+	s := newStatusServer(http.StatusInternalServerError)
+	defer s.Close()
+
+	code, err := newRetryingClient(s).Put(*request.New(), s.URL, ioutil.NopCloser(strings.NewReader("moo")))
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Len(t, s.bodies, 1, "a streamed body cannot be replayed, so the PUT must not be retried")
+}
+
+func Test_RetryingBackoff(t *testing.T) {
+	r := RetryingClient{InitialBackoff: time.Second, MaxBackoff: 8 * time.Second}
+
+	for attempt, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 10: 8 * time.Second, 100: 8 * time.Second} {
+		d := r.backoff(attempt)
+		assert.True(t, d >= expected/2 && d <= expected, "attempt %d: expected a backoff between %s and %s, got %s", attempt, expected/2, expected, d)
+	}
+}
+
+func Test_Retryable(t *testing.T) {
+	assert.True(t, Retryable(&StatusError{Code: http.StatusServiceUnavailable}))
+	assert.False(t, Retryable(&StatusError{Code: http.StatusUnauthorized}))
+	assert.False(t, Retryable(errors.New("moo")))
+
+	_, err := HttpImpl{HttpClient: http.DefaultClient}.Get(*request.New(), "http://[::1]:namedport")
+	assert.NotNil(t, err)
+	assert.False(t, Retryable(err), "expected a malformed url not to be retryable")
+}
+
+func Test_RetryingCancelled(t *testing.T) {
+	s := newStatusServer(http.StatusServiceUnavailable)
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := newRetryingClient(s)
+	client.InitialBackoff, client.MaxBackoff = time.Hour, time.Hour
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := client.Get(*request.New().WithContext(ctx), s.URL)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.True(t, time.Since(start) < 30*time.Second, "expected the backoff to be abandoned")
+	assert.Len(t, s.bodies, 1)
+
+	// a request whose context is already done is not made
+	_, err = client.Get(*request.New().WithContext(ctx), s.URL)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, Retryable(err))
+}
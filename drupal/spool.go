@@ -0,0 +1,116 @@
+package drupal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	DefaultSpoolMemoryThreshold = 8 << 20
+	DefaultSpoolMaxSize         = 1 << 30
+)
+
+// SpoolTooLargeErr is answered when the output being spooled exceeds the maximum size of the Spooler
+var SpoolTooLargeErr = errors.New("drupal: spooled body exceeds the maximum size")
+
+// Spooler buffers a body before it is uploaded, so it can be sent with a Content-Length and replayed if the upload
+// is retried.  Bodies are held in memory up to MemoryThreshold bytes, and are otherwise written to a temporary file.
+type Spooler struct {
+	// MemoryThreshold is the size of the largest body held in memory, DefaultSpoolMemoryThreshold if zero
+	MemoryThreshold int64
+	// MaxSize is the size of the largest body that may be spooled, DefaultSpoolMaxSize if zero
+	MaxSize int64
+	// Dir contains the temporary files, os.TempDir() if empty
+	Dir string
+}
+
+// Spool reads r until io.EOF, answering a SpooledBody of its content.  The caller must Remove the SpooledBody once it
+// is no longer needed.
+func (s Spooler) Spool(r io.Reader) (*SpooledBody, error) {
+	threshold, max := s.MemoryThreshold, s.MaxSize
+	if threshold == 0 {
+		threshold = DefaultSpoolMemoryThreshold
+	}
+	if max == 0 {
+		max = DefaultSpoolMaxSize
+	}
+	if threshold > max {
+		threshold = max
+	}
+
+	// read one byte more than the threshold, so a body of exactly the threshold is held in memory
+	buf := &bytes.Buffer{}
+	n, err := io.CopyN(buf, r, threshold+1)
+	if errors.Is(err, io.EOF) {
+		return newSpooledBody(buf.Bytes(), nil, n), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("drupal: unable to spool body: %w", err)
+	}
+
+	f, err := ioutil.TempFile(s.Dir, "spool-")
+	if err != nil {
+		return nil, fmt.Errorf("drupal: unable to spool body: %w", err)
+	}
+
+	// read one byte more than the maximum, to detect a body which exceeds it
+	if n, err = io.Copy(f, io.MultiReader(buf, io.LimitReader(r, max-n+1))); err == nil && n > max {
+		err = fmt.Errorf("%w (%d bytes)", SpoolTooLargeErr, max)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("drupal: unable to spool body to '%s': %w", f.Name(), err)
+	}
+
+	return newSpooledBody(nil, f, n), nil
+}
+
+// SpooledBody is a ReplayableBody of known size, held in memory or in a temporary file.  Closing a SpooledBody does
+// not release its storage, because it may be replayed after the HTTP client closes it; Remove releases the storage.
+type SpooledBody struct {
+	io.Reader
+	mem  []byte
+	file *os.File
+	size int64
+}
+
+func newSpooledBody(mem []byte, file *os.File, size int64) *SpooledBody {
+	b := &SpooledBody{mem: mem, file: file, size: size}
+	if file != nil {
+		b.Reader = io.NewSectionReader(file, 0, size)
+	} else {
+		b.Reader = bytes.NewReader(mem)
+	}
+	return b
+}
+
+// Size answers the length of the body in bytes
+func (b *SpooledBody) Size() int64 {
+	return b.size
+}
+
+func (b *SpooledBody) Close() error {
+	return nil
+}
+
+// Replay answers a SpooledBody sharing the storage of b, positioned at the beginning of the body
+func (b *SpooledBody) Replay() (io.ReadCloser, error) {
+	return newSpooledBody(b.mem, b.file, b.size), nil
+}
+
+// Remove releases the storage of the body, after which neither b nor its replays may be read
+func (b *SpooledBody) Remove() error {
+	if b.file == nil {
+		return nil
+	}
+
+	closeErr := b.file.Close()
+	if err := os.Remove(b.file.Name()); err != nil {
+		return err
+	}
+	return closeErr
+}
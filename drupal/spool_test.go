@@ -0,0 +1,94 @@
+package drupal
+
+import (
+	"derivative-ms/drupal/request"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_SpoolMemory(t *testing.T) {
+	dir := t.TempDir()
+	body, err := Spooler{MemoryThreshold: 3, Dir: dir}.Spool(strings.NewReader("moo"))
+	require.Nil(t, err)
+	defer body.Remove()
+
+	assert.Nil(t, body.file, "expected a body no larger than the threshold to be held in memory")
+	assert.Equal(t, int64(3), body.Size())
+	b, _ := ioutil.ReadAll(body)
+	assert.Equal(t, "moo", string(b))
+
+	entries, _ := os.ReadDir(dir)
+	assert.Empty(t, entries)
+}
+
+func Test_SpoolFile(t *testing.T) {
+	dir := t.TempDir()
+	body, err := Spooler{MemoryThreshold: 2, Dir: dir}.Spool(strings.NewReader("moo"))
+	require.Nil(t, err)
+
+	require.NotNil(t, body.file, "expected a body larger than the threshold to be written to a file")
+	assert.Equal(t, int64(3), body.Size())
+
+	for i := 0; i < 2; i++ {
+		replay, err := body.Replay()
+		require.Nil(t, err)
+		b, _ := ioutil.ReadAll(replay)
+		assert.Equal(t, "moo", string(b))
+		replay.Close()
+	}
+
+	require.Nil(t, body.Remove())
+	entries, _ := os.ReadDir(dir)
+	assert.Empty(t, entries, "expected the spooled file to be removed")
+}
+
+func Test_SpoolTooLarge(t *testing.T) {
+	dir := t.TempDir()
+	_, err := Spooler{MemoryThreshold: 1, MaxSize: 2, Dir: dir}.Spool(strings.NewReader("moo"))
+	assert.True(t, errors.Is(err, SpoolTooLargeErr))
+
+	entries, _ := os.ReadDir(dir)
+	assert.Empty(t, entries, "expected the spooled file to be removed")
+
+	// a body of exactly the maximum size is accepted
+	body, err := Spooler{MemoryThreshold: 1, MaxSize: 3, Dir: dir}.Spool(strings.NewReader("moo"))
+	require.Nil(t, err)
+	assert.Nil(t, body.Remove())
+}
+
+func Test_SpooledPut(t *testing.T) {
+	var (
+		contentLengths []int64
+		encodings      []string
+		bodies         []string
+		codes          = []int{http.StatusBadGateway, http.StatusOK}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		contentLengths = append(contentLengths, r.ContentLength)
+		encodings = append(encodings, strings.Join(r.TransferEncoding, ","))
+		w.WriteHeader(codes[0])
+		codes = codes[1:]
+	}))
+	defer server.Close()
+
+	body, err := Spooler{MemoryThreshold: 1, Dir: t.TempDir()}.Spool(strings.NewReader("moo"))
+	require.Nil(t, err)
+	defer body.Remove()
+
+	client := RetryingClient{Client: HttpImpl{HttpClient: server.Client()}, InitialBackoff: 1, MaxBackoff: 1}
+	code, err := client.Put(*request.New(), server.URL, body)
+	require.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"moo", "moo"}, bodies)
+	assert.Equal(t, []int64{3, 3}, contentLengths)
+	assert.Equal(t, []string{"", ""}, encodings, "expected the body not to be chunked")
+}
@@ -0,0 +1,142 @@
+package drupal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	DefaultDialTimeout           = 30 * time.Second
+	DefaultKeepAlive             = 30 * time.Second
+	DefaultTLSHandshakeTimeout   = 10 * time.Second
+	DefaultIdleConnTimeout       = 90 * time.Second
+	DefaultMaxIdleConns          = 100
+	DefaultMaxIdleConnsPerHost   = 10
+	DefaultExpectContinueTimeout = time.Second
+)
+
+// DefaultClient is used by handlers without transport configuration.  Connections to Drupal are kept alive and
+// pooled, and proxies are configured by the environment.
+var DefaultClient = mustNewClient(TransportConfig{})
+
+// TransportConfig configures the http.Client used to communicate with Drupal.  The zero value answers a client
+// with the Default* timeouts and pool sizes, using the system roots to verify Drupal's certificate.
+type TransportConfig struct {
+	// Timeout limits the duration of each request, including reading the response body; zero means no limit.
+	// Uploads of large derivatives may take some time, so the limit should be generous.
+	Timeout time.Duration
+	// DialTimeout limits the time taken to establish a TCP connection, DefaultDialTimeout if zero
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout limits the time taken by the TLS handshake, DefaultTLSHandshakeTimeout if zero
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout limits the time spent waiting for response headers once a request has been written; zero
+	// means no limit
+	ResponseHeaderTimeout time.Duration
+	// IdleConnTimeout is the time an idle connection remains in the pool, DefaultIdleConnTimeout if zero
+	IdleConnTimeout time.Duration
+	// MaxIdleConns limits the number of idle connections in the pool, DefaultMaxIdleConns if zero
+	MaxIdleConns int
+	// MaxIdleConnsPerHost limits the number of idle connections to each host, DefaultMaxIdleConnsPerHost if zero
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost limits the number of connections to each host; zero means no limit
+	MaxConnsPerHost int
+	// CaBundle is the path to PEM encoded certificates trusted in addition to the system roots
+	CaBundle string
+	// ClientCert and ClientKey are the paths to the PEM encoded certificate and key presented to Drupal
+	ClientCert, ClientKey string
+	// Proxy is the URL of the proxy used for all requests.  If empty, the proxy is configured by the HTTP_PROXY,
+	// HTTPS_PROXY, and NO_PROXY environment variables.
+	Proxy string
+}
+
+// NewClient answers an http.Client configured by c
+func (c TransportConfig) NewClient() (*http.Client, error) {
+	var (
+		tlsConfig = &tls.Config{}
+		proxy     = http.ProxyFromEnvironment
+	)
+
+	if c.CaBundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		pemCerts, err := os.ReadFile(c.CaBundle)
+		if err != nil {
+			return nil, fmt.Errorf("drupal: unable to read CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pemCerts) {
+			return nil, fmt.Errorf("drupal: no PEM encoded certificates found in CA bundle '%s'", c.CaBundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.ClientCert != "" || c.ClientKey != "" {
+		if c.ClientCert == "" || c.ClientKey == "" {
+			return nil, errors.New("drupal: a client certificate and key must be supplied together")
+		}
+
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("drupal: unable to load client certificate '%s': %w", c.ClientCert, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.Proxy != "" {
+		u, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("drupal: invalid proxy url '%s': %w", c.Proxy, err)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   orDefault(c.DialTimeout, DefaultDialTimeout),
+			KeepAlive: DefaultKeepAlive,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   orDefault(c.TLSHandshakeTimeout, DefaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: c.ResponseHeaderTimeout,
+		IdleConnTimeout:       orDefault(c.IdleConnTimeout, DefaultIdleConnTimeout),
+		MaxIdleConns:          DefaultMaxIdleConns,
+		MaxIdleConnsPerHost:   DefaultMaxIdleConnsPerHost,
+		MaxConnsPerHost:       c.MaxConnsPerHost,
+		ExpectContinueTimeout: DefaultExpectContinueTimeout,
+	}
+
+	if c.MaxIdleConns > 0 {
+		transport.MaxIdleConns = c.MaxIdleConns
+	}
+	if c.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	}
+
+	return &http.Client{Transport: transport, Timeout: c.Timeout}, nil
+}
+
+func mustNewClient(c TransportConfig) *http.Client {
+	client, err := c.NewClient()
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+func orDefault(d, defaultValue time.Duration) time.Duration {
+	if d == 0 {
+		return defaultValue
+	}
+	return d
+}
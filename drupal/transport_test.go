@@ -0,0 +1,151 @@
+package drupal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"derivative-ms/drupal/request"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writePem writes the PEM block to a file in dir, answering its path
+func writePem(t *testing.T, dir, name, blockType string, b []byte) string {
+	file := filepath.Join(dir, name)
+	require.Nil(t, ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: b}), 0600))
+	return file
+}
+
+func Test_TransportKeepAlive(t *testing.T) {
+	var conns int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	client, err := TransportConfig{}.NewClient()
+	require.Nil(t, err)
+	h := HttpImpl{HttpClient: client}
+
+	for i := 0; i < 3; i++ {
+		body, err := h.Get(*request.New(), server.URL)
+		require.Nil(t, err)
+		body.Close()
+		_, err = h.Put(*request.New(), server.URL, ioutil.NopCloser(strings.NewReader("moo")))
+		require.Nil(t, err)
+	}
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&conns), "expected requests to reuse a pooled connection")
+}
+
+func Test_TransportCaBundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// the server's certificate is not trusted by default
+	client, err := TransportConfig{}.NewClient()
+	require.Nil(t, err)
+	_, err = HttpImpl{HttpClient: client}.Get(*request.New(), server.URL)
+	assert.NotNil(t, err)
+
+	bundle := writePem(t, t.TempDir(), "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+	client, err = TransportConfig{CaBundle: bundle}.NewClient()
+	require.Nil(t, err)
+	body, err := HttpImpl{HttpClient: client}.Get(*request.New(), server.URL)
+	require.Nil(t, err)
+	body.Close()
+
+	_, err = TransportConfig{CaBundle: filepath.Join(t.TempDir(), "moo.pem")}.NewClient()
+	assert.NotNil(t, err)
+}
+
+func Test_TransportClientCert(t *testing.T) {
+	var (
+		dir     = t.TempDir()
+		subject string
+	)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "derivative-ms"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.Nil(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject = r.TLS.PeerCertificates[0].Subject.CommonName
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	client, err := TransportConfig{
+		CaBundle:   writePem(t, dir, "ca.pem", "CERTIFICATE", server.Certificate().Raw),
+		ClientCert: writePem(t, dir, "client.pem", "CERTIFICATE", der),
+		ClientKey:  writePem(t, dir, "client-key.pem", "EC PRIVATE KEY", keyDer),
+	}.NewClient()
+	require.Nil(t, err)
+
+	body, err := HttpImpl{HttpClient: client}.Get(*request.New(), server.URL)
+	require.Nil(t, err)
+	body.Close()
+	assert.Equal(t, "derivative-ms", subject)
+
+	_, err = TransportConfig{ClientCert: filepath.Join(dir, "client.pem")}.NewClient()
+	assert.NotNil(t, err, "expected an error when the client key is missing")
+}
+
+func Test_TransportProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	client, err := TransportConfig{Proxy: proxy.URL}.NewClient()
+	require.Nil(t, err)
+	body, err := HttpImpl{HttpClient: client}.Get(*request.New(), "http://drupal.example.org/moo.jpg")
+	require.Nil(t, err)
+	body.Close()
+	assert.Equal(t, "http://drupal.example.org/moo.jpg", proxied)
+}
+
+func Test_TransportTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	client, err := TransportConfig{ResponseHeaderTimeout: 10 * time.Millisecond}.NewClient()
+	require.Nil(t, err)
+	_, err = HttpImpl{HttpClient: client}.Get(*request.New(), server.URL)
+	assert.NotNil(t, err)
+	assert.True(t, Retryable(err), "expected a timeout to be retryable")
+}
@@ -0,0 +1,105 @@
+package handler
+
+import "derivative-ms/api"
+
+// commandParameters are accepted by every handler that runs a command to produce a derivative
+var commandParameters = []Parameter{
+	{"destinations", false, "destinations (queues) the handler responds to, as names or path.Match patterns"},
+	{"args", false, "options messages may supply to the command: 'allow' and 'deny' lists"},
+	{"credentials", false, "credentials presented to Drupal: 'type' of 'jwt', 'basic', or 'bearer'"},
+	{"retry", false, "retries of failed Drupal requests: 'attempts', 'initialBackoff', and 'maxBackoff'"},
+	{"http", false, "connections to Drupal: timeouts, connection pool sizes, 'caBundle', client certificate, and 'proxy'"},
+	{"spool", false, "buffers output before uploading it: 'memoryThreshold', 'maxSize', and 'dir'"},
+}
+
+// withCommandParameters answers the parameters of a handler that runs a command: the 'commandPath', the supplied
+// parameters specific to the handler, and the commandParameters
+func withCommandParameters(parameters ...Parameter) []Parameter {
+	result := []Parameter{{"commandPath", true, "path to the command executed for each message"}}
+	result = append(result, parameters...)
+	return append(result, commandParameters...)
+}
+
+func init() {
+	Register(Registration{
+		Type:        "JWTLoggingHandler",
+		Description: "logs the claims of the JWT carried by each message",
+		New:         func() api.Handler { return &JWTLoggingHandler{} },
+	})
+
+	Register(Registration{
+		Type:        "JWTHandler",
+		Description: "verifies the JWT carried by each message, rejecting messages with unacceptable tokens",
+		Parameters: []Parameter{
+			{"requireTokens", true, "reject messages without a JWT"},
+			{"verifyTokens", true, "verify the signature and claims of each JWT"},
+			{"issuer", false, "the required 'iss' claim"},
+			{"audience", false, "accepted values of the 'aud' claim"},
+			{"leeway", false, "clock skew tolerated when checking 'exp' and 'nbf'"},
+			{"keyFiles", false, "PEM files containing verification keys"},
+			{"jwks", false, "files or URLs of JSON Web Key Sets containing verification keys"},
+			{"keyRefreshInterval", false, "minimum interval between reads of a JWKS"},
+			{"serviceAccount", false, "mints tokens for messages whose JWT is missing or expired: 'claims', 'ttl', and 'algorithm'"},
+		},
+		New: func() api.Handler { return &JWTHandler{} },
+	})
+
+	Register(Registration{
+		Type:        "ImageMagickHandler",
+		Description: "converts images with ImageMagick (Houdini)",
+		Parameters: withCommandParameters(
+			Parameter{"defaultMediaType", true, "media type produced when the message does not specify one"},
+			Parameter{"acceptedFormats", true, "media types that may be produced"},
+		),
+		New: func() api.Handler { return &ImageMagickHandler{} },
+	})
+
+	Register(Registration{
+		Type:        "FFMpegHandler",
+		Description: "converts audio and video with FFmpeg (Homarus)",
+		Parameters: withCommandParameters(
+			Parameter{"defaultMediaType", true, "media type produced when the message does not specify one"},
+			Parameter{"acceptedFormatsMap", true, "media types that may be produced, mapped to FFmpeg output formats"},
+		),
+		New: func() api.Handler { return &FFMpegHandler{} },
+	})
+
+	Register(Registration{
+		Type:        "TesseractHandler",
+		Description: "extracts text from images with Tesseract OCR (Hypercube)",
+		Parameters:  withCommandParameters(),
+		New:         func() api.Handler { return &TesseractHandler{} },
+	})
+
+	Register(Registration{
+		Type:        "Pdf2TextHandler",
+		Description: "extracts text from PDFs with pdftotext (Hypercube)",
+		Parameters:  withCommandParameters(),
+		New:         func() api.Handler { return &Pdf2TextHandler{} },
+	})
+
+	Register(Registration{
+		Type:        "FITSHandler",
+		Description: "produces technical metadata with the File Information Tool Set",
+		Parameters: withCommandParameters(
+			Parameter{"defaultMediaType", true, "media type of the FITS output"},
+		),
+		New: func() api.Handler { return &FITSHandler{} },
+	})
+
+	Register(Registration{
+		Type:        "ExecHandler",
+		Description: "runs a command configured by its parameters, producing a derivative from its stdout",
+		// destinations are required, there are no default destinations, so commandParameters[0] is replaced
+		Parameters: append([]Parameter{
+			{"commandPath", true, "path to the command executed for each message"},
+			{"destinations", true, "destinations (queues) the handler responds to, as names or path.Match patterns"},
+			{"argsTemplate", false, "arguments of the command, with placeholders {args}, {mimetype}, {format}, and {source}"},
+			{"input", false, "how the command reads the source: 'stdin' (the default) or 'file'"},
+			{"defaultMediaType", false, "media type requested when the message does not specify one"},
+			{"acceptedFormats", false, "media types that may be requested; if absent, any media type is accepted"},
+			{"outputMediaType", false, "media type of the output; if absent, the media type requested"},
+		}, commandParameters[1:]...),
+		New: func() api.Handler { return &ExecHandler{} },
+	})
+}
@@ -0,0 +1,265 @@
+package handler
+
+import (
+	"context"
+	"derivative-ms/config"
+	"derivative-ms/drupal"
+	"derivative-ms/drupal/request"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"time"
+)
+
+const (
+	credentialsJwt    = "jwt"
+	credentialsBasic  = "basic"
+	credentialsBearer = "bearer"
+)
+
+// configureHttpClient answers the http.Client used by a handler to communicate with Drupal, as configured by the
+// optional 'http' parameter of the handler.  If the parameter is absent, drupal.DefaultClient is answered.
+func configureHttpClient(handlerConfig *map[string]interface{}) (*http.Client, error) {
+	httpConfig, err := config.MapValue(handlerConfig, "http")
+	if errors.Is(err, config.NotFoundErr) {
+		return drupal.DefaultClient, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	transport := drupal.TransportConfig{}
+
+	for k, dest := range map[string]*time.Duration{
+		"timeout":               &transport.Timeout,
+		"dialTimeout":           &transport.DialTimeout,
+		"tlsHandshakeTimeout":   &transport.TLSHandshakeTimeout,
+		"responseHeaderTimeout": &transport.ResponseHeaderTimeout,
+		"idleConnTimeout":       &transport.IdleConnTimeout,
+	} {
+		if *dest, err = config.DurationValue(&httpConfig, k); err != nil && !errors.Is(err, config.NotFoundErr) {
+			return nil, err
+		}
+	}
+
+	for k, dest := range map[string]*int{
+		"maxIdleConns":        &transport.MaxIdleConns,
+		"maxIdleConnsPerHost": &transport.MaxIdleConnsPerHost,
+		"maxConnsPerHost":     &transport.MaxConnsPerHost,
+	} {
+		if *dest, err = config.IntValue(&httpConfig, k); err != nil && !errors.Is(err, config.NotFoundErr) {
+			return nil, err
+		}
+	}
+
+	for k, dest := range map[string]*string{
+		"caBundle":   &transport.CaBundle,
+		"clientCert": &transport.ClientCert,
+		"clientKey":  &transport.ClientKey,
+		"proxy":      &transport.Proxy,
+	} {
+		if *dest, err = config.StringValue(&httpConfig, k); err != nil && !errors.Is(err, config.NotFoundErr) {
+			return nil, err
+		}
+	}
+
+	return transport.NewClient()
+}
+
+// configureDrupal answers the drupal.Client used by a handler, which retries failed requests as configured by the
+// 'retry' parameter of the handler: the maximum number of 'attempts', the 'initialBackoff', and the 'maxBackoff'.  If
+// the parameter is absent, the drupal.RetryingClient defaults apply.  A usable client is answered even if the
+// parameter cannot be parsed.  Requests are made by httpClient, or drupal.DefaultClient if it is nil.
+func configureDrupal(handlerConfig *map[string]interface{}, httpClient *http.Client, credentials drupal.Credentials) (drupal.Client, error) {
+	if httpClient == nil {
+		httpClient = drupal.DefaultClient
+	}
+	client := drupal.RetryingClient{Client: drupal.HttpImpl{HttpClient: httpClient, Credentials: credentials}}
+
+	retryConfig, err := config.MapValue(handlerConfig, "retry")
+	if errors.Is(err, config.NotFoundErr) {
+		return client, nil
+	} else if err != nil {
+		return client, err
+	}
+
+	if client.Attempts, err = config.IntValue(&retryConfig, "attempts"); err != nil && !errors.Is(err, config.NotFoundErr) {
+		return client, err
+	}
+
+	if client.InitialBackoff, err = config.DurationValue(&retryConfig, "initialBackoff"); err != nil && !errors.Is(err, config.NotFoundErr) {
+		return client, err
+	}
+
+	if client.MaxBackoff, err = config.DurationValue(&retryConfig, "maxBackoff"); err != nil && !errors.Is(err, config.NotFoundErr) {
+		return client, err
+	}
+
+	return client, nil
+}
+
+// configureSpooler answers the drupal.Spooler configured by the optional 'spool' parameter of a handler, which may
+// contain the 'memoryThreshold' and 'maxSize' in bytes, and the 'dir' holding temporary files.  If the parameter is
+// absent, nil is answered and the output of the handler is streamed to Drupal.
+func configureSpooler(handlerConfig *map[string]interface{}) (*drupal.Spooler, error) {
+	spoolConfig, err := config.MapValue(handlerConfig, "spool")
+	if errors.Is(err, config.NotFoundErr) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var (
+		spooler              = &drupal.Spooler{}
+		memoryThreshold, max int
+	)
+
+	if memoryThreshold, err = config.IntValue(&spoolConfig, "memoryThreshold"); err != nil && !errors.Is(err, config.NotFoundErr) {
+		return nil, err
+	}
+	spooler.MemoryThreshold = int64(memoryThreshold)
+
+	if max, err = config.IntValue(&spoolConfig, "maxSize"); err != nil && !errors.Is(err, config.NotFoundErr) {
+		return nil, err
+	}
+	spooler.MaxSize = int64(max)
+
+	if spooler.Dir, err = config.StringValue(&spoolConfig, "dir"); err != nil && !errors.Is(err, config.NotFoundErr) {
+		return nil, err
+	}
+
+	return spooler, nil
+}
+
+// upload PUTs the stdout of the started command c to uri, and waits for c to exit.  If spooler is nil, stdout is
+// streamed to Drupal while c is running.  Otherwise stdout is spooled until c exits, and is only uploaded if c
+// succeeded; the spooled body has a Content-Length, and can be replayed if the upload is retried.
+func upload(ctx context.Context, d drupal.Client, spooler *drupal.Spooler, reqCtx request.Context, uri string, stdout io.ReadCloser, c *exec.Cmd) error {
+	if spooler == nil {
+		if _, err := d.Put(reqCtx, uri, cancelableReader{ctx, stdout}); err != nil {
+			// stdout may no longer be read, so c is killed rather than waiting for it to finish writing
+			killProcessGroup(c)
+			c.Wait()
+			return err
+		}
+
+		return wait(c)
+	}
+
+	body, err := spooler.Spool(cancelableReader{ctx, stdout})
+	if err != nil {
+		// stdout is no longer read, so c is killed rather than waiting for it to finish writing
+		killProcessGroup(c)
+		c.Wait()
+		return err
+	}
+	defer body.Remove()
+
+	if err = wait(c); err != nil {
+		return err
+	}
+
+	_, err = d.Put(reqCtx, uri, body)
+	return err
+}
+
+// localCopy GETs the source from Drupal, copying it to a temporary file whose name begins with prefix, and answers the
+// path of the file.  The extension of the source is retained, because some commands rely on it to identify the
+// format of the file.  The caller is responsible for removing the file.
+func localCopy(ctx context.Context, d drupal.Client, reqCtx request.Context, sourceUri, prefix string) (string, error) {
+	sourceStream, err := d.Get(reqCtx, sourceUri)
+	if err != nil {
+		return "", err
+	}
+	defer sourceStream.Close()
+
+	var ext string
+	if u, err := url.Parse(sourceUri); err == nil {
+		ext = path.Ext(u.Path)
+	}
+
+	sourceFile, err := ioutil.TempFile("", prefix+"*"+ext)
+	if err != nil {
+		return "", fmt.Errorf("handler: unable to create temporary file for '%s': %w", sourceUri, err)
+	}
+
+	_, err = io.Copy(sourceFile, cancelableReader{ctx, sourceStream})
+	if closeErr := sourceFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(sourceFile.Name())
+		return "", fmt.Errorf("handler: unable to copy '%s' to '%s': %w", sourceUri, sourceFile.Name(), err)
+	}
+
+	return sourceFile.Name(), nil
+}
+
+// configureCredentials answers the drupal.Credentials configured by the 'credentials' parameter of a handler.  If the
+// parameter is absent, the JWT carried by each message is passed through to Drupal.
+func configureCredentials(handlerConfig *map[string]interface{}) (drupal.Credentials, error) {
+	credentialsConfig, err := config.MapValue(handlerConfig, "credentials")
+	if errors.Is(err, config.NotFoundErr) {
+		return drupal.JwtCredentials{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	credentialsType, err := config.StringValue(&credentialsConfig, "type")
+	if err != nil {
+		return nil, err
+	}
+
+	switch credentialsType {
+	case credentialsJwt:
+		return drupal.JwtCredentials{}, nil
+
+	case credentialsBasic:
+		user, err := secretValue(&credentialsConfig, "username")
+		if err != nil {
+			return nil, err
+		}
+		pass, err := secretValue(&credentialsConfig, "password")
+		if err != nil {
+			return nil, err
+		}
+		return drupal.BasicAuthCredentials{User: user, Pass: pass}, nil
+
+	case credentialsBearer:
+		token, err := secretValue(&credentialsConfig, "token")
+		if err != nil {
+			return nil, err
+		}
+		return drupal.BearerCredentials{Token: token}, nil
+	}
+
+	return nil, fmt.Errorf("unknown credentials type '%s', expected one of '%s', '%s', or '%s'",
+		credentialsType, credentialsJwt, credentialsBasic, credentialsBearer)
+}
+
+// secretValue resolves the secret configured by the key: its literal value is keyed by key, the environment variable
+// containing it by key + "Env", and the file containing it by key + "File".
+func secretValue(jsonBlob *map[string]interface{}, key string) (string, error) {
+	var (
+		secret = drupal.Secret{}
+		err    error
+	)
+
+	for k, dest := range map[string]*string{key: &secret.Value, key + "Env": &secret.Env, key + "File": &secret.File} {
+		if *dest, err = config.StringValue(jsonBlob, k); err != nil && !errors.Is(err, config.NotFoundErr) {
+			return "", err
+		}
+	}
+
+	value, err := secret.Resolve()
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve '%s': %w", key, err)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"context"
+	"derivative-ms/api"
+	"derivative-ms/cmd"
+	"derivative-ms/config"
+	"derivative-ms/drupal"
+	"derivative-ms/drupal/request"
+	"derivative-ms/logging"
+	"errors"
+	"fmt"
+	"github.com/cristalhq/jwt/v4"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+)
+
+const (
+	// InputStdin streams the source to the stdin of the command
+	InputStdin = "stdin"
+	// InputFile copies the source to a temporary file, which is named by the {source} placeholder
+	InputFile = "file"
+)
+
+// ExecHandler runs a command configured entirely by the handler configuration, so new kinds of derivative may be
+// produced without writing a handler.  The source is retrieved from Drupal and supplied to the command on its stdin,
+// or as a temporary file, and the stdout of the command is PUT to Drupal as the derivative.
+type ExecHandler struct {
+	config.Configuration
+	Drupal         drupal.Client
+	Credentials    drupal.Credentials
+	Spooler        *drupal.Spooler
+	CommandBuilder cmd.Builder
+	CommandPath    string
+	Destinations   config.Destinations
+	ArgPolicy      cmd.ArgPolicy
+	// Input is InputStdin or InputFile
+	Input string
+	// DefaultMediaType is requested of the command when the message does not specify a media type
+	DefaultMediaType string
+	// AcceptedFormats are the media types the command may be requested to produce; if empty, any media type is accepted
+	AcceptedFormats map[string]struct{}
+	// OutputMediaType is the media type of the output of the command; if empty, it is the media type requested
+	OutputMediaType string
+}
+
+func (h *ExecHandler) Handle(ctx context.Context, t *jwt.Token, b *api.MessageBody) (context.Context, error) {
+	if !h.Destinations.Matches(ctx.Value(api.MsgDestination).(string)) {
+		return ctx, nil
+	}
+
+	// reject options supplied by the message that are not permitted, before any command is run
+	if err := h.ArgPolicy.Check(b.Attachment.Content.Args); err != nil {
+		return ctx, err
+	}
+
+	var (
+		logger = logging.FromContext(ctx)
+		reqCtx = request.New().WithToken(t).WithContext(ctx)
+
+		// original file from Drupal, streamed to stdin
+		sourceStream io.ReadCloser
+		// command stdout
+		stdout io.ReadCloser
+
+		cmd *exec.Cmd
+		err error
+	)
+
+	if b.Attachment.Content.MimeType == "" {
+		b.Attachment.Content.MimeType = h.DefaultMediaType
+	}
+
+	if len(h.AcceptedFormats) > 0 {
+		if _, ok := h.AcceptedFormats[b.Attachment.Content.MimeType]; !ok {
+			return ctx, fmt.Errorf("handler: '%s' does not support mime type '%s'", h.Key, b.Attachment.Content.MimeType)
+		}
+	}
+
+	// GET the original file from Drupal, streaming it to the command or copying it to the local filesystem
+	// PUT the output of the command to Drupal
+	if h.Input == InputFile {
+		var sourceFile string
+		if sourceFile, err = localCopy(ctx, h.Drupal, *reqCtx, b.Attachment.Content.SourceUri, "exec-"); err != nil {
+			return ctx, err
+		}
+		defer os.Remove(sourceFile)
+
+		// the command reads the local copy, the message body is otherwise unchanged
+		localBody := *b
+		localBody.Attachment.Content.SourceUri = sourceFile
+		if cmd, err = h.CommandBuilder.Build(h.CommandPath, t, &localBody); err != nil {
+			return ctx, err
+		}
+	} else {
+		if cmd, err = h.CommandBuilder.Build(h.CommandPath, t, b); err != nil {
+			return ctx, err
+		}
+
+		if sourceStream, err = h.Drupal.Get(*reqCtx, b.Attachment.Content.SourceUri); err != nil {
+			return ctx, err
+		}
+		defer sourceStream.Close()
+		cmd.Stdin = cancelableReader{ctx, sourceStream}
+	}
+
+	if stdout, err = cmd.StdoutPipe(); err != nil {
+		return ctx, err
+	}
+
+	logger.Debug("handler: executing command", "command", cmd.String())
+	var stop func()
+	if stop, err = start(ctx, cmd); err != nil {
+		return ctx, err
+	}
+	defer stop()
+
+	contentType := h.OutputMediaType
+	if contentType == "" {
+		contentType = b.Attachment.Content.MimeType
+	}
+
+	reqCtx.WithHeader("Content-Location", b.Attachment.Content.UploadUri).
+		WithHeader("Content-Type", contentType)
+	if err = upload(ctx, h.Drupal, h.Spooler, *reqCtx, b.Attachment.Content.DestinationUri, stdout, cmd); err != nil {
+		return ctx, err
+	}
+
+	return api.Handled(ctx, h.Key), nil
+}
+
+func (h *ExecHandler) Configure(c config.Configuration) error {
+	return h.configure(c, false)
+}
+
+func (h *ExecHandler) configure(c config.Configuration, ignoreErr bool) error {
+	var (
+		execConfig *map[string]interface{}
+		template   []string
+		formats    []string
+		err        error
+	)
+	h.Configuration = c
+
+	if execConfig, err = h.UnmarshalHandlerConfig(); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure ExecHandler: %w", err)
+	}
+
+	if h.CommandPath, err = config.StringValue(execConfig, "commandPath"); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure ExecHandler '%s', parameter '%s': %w", h.Key, "commandPath", err)
+	}
+
+	// there is no default destination, the handler must name the destinations it responds to
+	if h.Destinations, err = config.DestinationsValue(execConfig, "destinations"); err == nil && len(h.Destinations) == 0 {
+		err = fmt.Errorf("at least one destination is required: %w", config.NotFoundErr)
+	}
+	if err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure ExecHandler '%s', parameter '%s': %w", h.Key, "destinations", err)
+	}
+
+	if h.ArgPolicy, err = configureArgPolicy(execConfig); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure ExecHandler '%s', parameter '%s': %w", h.Key, "args", err)
+	}
+
+	if template, err = config.SliceStringValue(execConfig, "argsTemplate"); err != nil && !errors.Is(err, config.NotFoundErr) && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure ExecHandler '%s', parameter '%s': %w", h.Key, "argsTemplate", err)
+	}
+
+	if h.Input, err = config.StringValue(execConfig, "input"); errors.Is(err, config.NotFoundErr) {
+		h.Input, err = InputStdin, nil
+	} else if err == nil && h.Input != InputStdin && h.Input != InputFile {
+		err = fmt.Errorf("expected '%s' or '%s', got '%s'", InputStdin, InputFile, h.Input)
+	}
+	if err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure ExecHandler '%s', parameter '%s': %w", h.Key, "input", err)
+	}
+
+	if h.DefaultMediaType, err = config.StringValue(execConfig, "defaultMediaType"); err != nil && !errors.Is(err, config.NotFoundErr) && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure ExecHandler '%s', parameter '%s': %w", h.Key, "defaultMediaType", err)
+	}
+
+	if h.OutputMediaType, err = config.StringValue(execConfig, "outputMediaType"); err != nil && !errors.Is(err, config.NotFoundErr) && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure ExecHandler '%s', parameter '%s': %w", h.Key, "outputMediaType", err)
+	}
+
+	if formats, err = config.SliceStringValue(execConfig, "acceptedFormats"); err != nil && !errors.Is(err, config.NotFoundErr) && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure ExecHandler '%s', parameter '%s': %w", h.Key, "acceptedFormats", err)
+	}
+
+	h.AcceptedFormats = make(map[string]struct{})
+
+	for _, f := range formats {
+		h.AcceptedFormats[f] = struct{}{}
+	}
+
+	if h.Credentials, err = configureCredentials(execConfig); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure ExecHandler '%s', parameter '%s': %w", h.Key, "credentials", err)
+	}
+
+	if h.Spooler, err = configureSpooler(execConfig); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure ExecHandler '%s', parameter '%s': %w", h.Key, "spool", err)
+	}
+
+	if h.Drupal == nil {
+		var client *http.Client
+		if client, err = configureHttpClient(execConfig); err != nil && !ignoreErr {
+			return fmt.Errorf("handler: unable to configure ExecHandler '%s', parameter '%s': %w", h.Key, "http", err)
+		}
+
+		if h.Drupal, err = configureDrupal(execConfig, client, h.Credentials); err != nil && !ignoreErr {
+			return fmt.Errorf("handler: unable to configure ExecHandler '%s', parameter '%s': %w", h.Key, "retry", err)
+		}
+	}
+
+	if h.CommandBuilder == nil {
+		h.CommandBuilder = cmd.Template{Args: template, Stdin: h.Input == InputStdin}
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"derivative-ms/api"
+	"derivative-ms/cmd"
+	"derivative-ms/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+const execDestination = "/queue/derivative-ms-exec"
+
+// newExecHandler answers an ExecHandler configured by handlerConfig, and the mock Drupal client it uses
+func newExecHandler(t *testing.T, handlerConfig map[string]interface{}) (*ExecHandler, *mockDrupal) {
+	d := &mockDrupal{}
+	h := &ExecHandler{Drupal: d}
+	require.Nil(t, h.Configure(config.Configuration{
+		Key:    "exec",
+		Type:   "ExecHandler",
+		Config: &config.Config{Json: map[string]interface{}{"exec": handlerConfig}},
+	}))
+	return h, d
+}
+
+func newExecMessage(source, mimeType, args string) *api.MessageBody {
+	b := &api.MessageBody{}
+	b.Attachment.Content.SourceUri = source
+	b.Attachment.Content.MimeType = mimeType
+	b.Attachment.Content.Args = args
+	b.Attachment.Content.DestinationUri = "http://example.org/moo/media"
+	return b
+}
+
+func Test_ExecHandlerStdin(t *testing.T) {
+	shPath, err := exec.LookPath("sh")
+	require.Nil(t, err)
+	h, d := newExecHandler(t, map[string]interface{}{
+		"commandPath":     shPath,
+		"argsTemplate":    []interface{}{"-c", `echo "$1" {format}; cat {source}`, "sh", "{args}"},
+		"destinations":    []interface{}{execDestination},
+		"outputMediaType": "text/plain",
+	})
+	d.get.retBody = ioutil.NopCloser(strings.NewReader("moo"))
+	ctx := newContext("moo-msg-id", execDestination, api.MessageBody{})
+
+	handledCtx, err := h.Handle(ctx.ctx, nil, newExecMessage("http://example.org/moo.jpg", "image/png", "'moo cow'"))
+	require.Nil(t, err)
+	assert.Equal(t, []string{"exec"}, api.HandledBy(handledCtx))
+	assert.Equal(t, "http://example.org/moo.jpg", d.get.uri)
+	assert.Equal(t, "moo cow png\nmoo", string(d.put.body))
+	assert.Equal(t, "text/plain", d.put.reqCtx.Headers()["Content-Type"])
+}
+
+func Test_ExecHandlerNoArgPolicy(t *testing.T) {
+	echoPath, err := exec.LookPath("echo")
+	require.Nil(t, err)
+	h, d := newExecHandler(t, map[string]interface{}{
+		"commandPath":  echoPath,
+		"argsTemplate": []interface{}{"{args}"},
+		"destinations": []interface{}{execDestination},
+		"input":        "file",
+	})
+	assert.Equal(t, cmd.ArgPolicy{}, h.ArgPolicy)
+	ctx := newContext("moo-msg-id", execDestination, api.MessageBody{})
+
+	// without an 'args' parameter, messages may supply any option
+	d.get.retBody = ioutil.NopCloser(strings.NewReader("moo"))
+	_, err = h.Handle(ctx.ctx, nil, newExecMessage("http://example.org/moo.jpg", "text/plain", "-thumbnail 100x100"))
+	require.Nil(t, err)
+	assert.Equal(t, "-thumbnail 100x100\n", string(d.put.body))
+
+	// but not values which may refer to a file
+	d.put.body = nil
+	_, err = h.Handle(ctx.ctx, nil, newExecMessage("http://example.org/moo.jpg", "text/plain", "-thumbnail 100x100 /etc/passwd"))
+	assert.ErrorIs(t, err, cmd.ArgsPolicyErr)
+	assert.Nil(t, d.put.body)
+}
+
+func Test_ExecHandlerFile(t *testing.T) {
+	catPath, err := exec.LookPath("cat")
+	require.Nil(t, err)
+	h, d := newExecHandler(t, map[string]interface{}{
+		"commandPath":      catPath,
+		"argsTemplate":     []interface{}{"{source}"},
+		"input":            "file",
+		"destinations":     []interface{}{execDestination},
+		"defaultMediaType": "application/json",
+		"acceptedFormats":  []interface{}{"application/json"},
+	})
+	d.get.retBody = ioutil.NopCloser(strings.NewReader(`{"moo": "cow"}`))
+	ctx := newContext("moo-msg-id", execDestination, api.MessageBody{})
+
+	_, err = h.Handle(ctx.ctx, nil, newExecMessage("http://example.org/moo.json", "", ""))
+	require.Nil(t, err)
+	assert.Equal(t, `{"moo": "cow"}`, string(d.put.body))
+	assert.Equal(t, "application/json", d.put.reqCtx.Headers()["Content-Type"])
+
+	// media types which are not accepted are rejected
+	_, err = h.Handle(ctx.ctx, nil, newExecMessage("http://example.org/moo.json", "text/plain", ""))
+	assert.EqualError(t, err, "handler: 'exec' does not support mime type 'text/plain'")
+
+	// messages sent to other destinations are ignored
+	ctx = newContext("moo-msg-id", config.HoudiniDestination, api.MessageBody{})
+	handledCtx, err := h.Handle(ctx.ctx, nil, newExecMessage("http://example.org/moo.json", "", ""))
+	assert.Nil(t, err)
+	assert.Empty(t, api.HandledBy(handledCtx))
+}
+
+func Test_ExecHandlerConfigure(t *testing.T) {
+	configure := func(handlerConfig map[string]interface{}) error {
+		return (&ExecHandler{Drupal: &mockDrupal{}}).Configure(config.Configuration{
+			Key:    "exec",
+			Config: &config.Config{Json: map[string]interface{}{"exec": handlerConfig}},
+		})
+	}
+
+	assert.ErrorIs(t, configure(map[string]interface{}{"commandPath": "cat"}), config.NotFoundErr, "expected destinations to be required")
+	assert.NotNil(t, configure(map[string]interface{}{"commandPath": "cat", "destinations": []interface{}{execDestination}, "input": "moo"}))
+	assert.Nil(t, configure(map[string]interface{}{"commandPath": "cat", "destinations": []interface{}{execDestination}}))
+}
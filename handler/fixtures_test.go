@@ -126,6 +126,11 @@ type tesseractSuite struct {
 	handler *TesseractHandler
 }
 
+type fitsSuite struct {
+	suite
+	handler *FITSHandler
+}
+
 type mutableHandler interface {
 	api.Handler
 	setCommandBuilder(c cmd.Builder)
@@ -179,3 +184,15 @@ func (s tesseractSuite) setCommandBuilder(c cmd.Builder) {
 func (s tesseractSuite) setCommandPath(cmdPath string) {
 	s.handler.CommandPath = cmdPath
 }
+
+func (s fitsSuite) Handle(ctx context.Context, t *jwt.Token, b *api.MessageBody) (context.Context, error) {
+	return s.handler.Handle(ctx, t, b)
+}
+
+func (s fitsSuite) setCommandBuilder(c cmd.Builder) {
+	s.handler.CommandBuilder = c
+}
+
+func (s fitsSuite) setCommandPath(cmdPath string) {
+	s.handler.CommandPath = cmdPath
+}
@@ -2,22 +2,17 @@ package handler
 
 import (
 	"bufio"
-	"bytes"
 	"context"
-	"crypto/rsa"
-	"crypto/x509"
 	"derivative-ms/api"
 	"derivative-ms/cmd"
 	"derivative-ms/config"
 	"derivative-ms/drupal"
 	"derivative-ms/drupal/request"
-	"derivative-ms/env"
-	"encoding/pem"
+	"derivative-ms/logging"
+	"errors"
 	"fmt"
 	"github.com/cristalhq/jwt/v4"
 	"io"
-	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"os/exec"
@@ -25,51 +20,82 @@ import (
 	"time"
 )
 
-var httpClient = &http.Client{}
-
 type CompositeHandler struct {
 	Handlers []api.Handler
 }
 
-// TODO use plugin mechanism for handlers?
 type ImageMagickHandler struct {
 	config.Configuration
 	Drupal           drupal.Client
+	Credentials      drupal.Credentials
+	Spooler          *drupal.Spooler
 	CommandBuilder   cmd.Builder
 	DefaultMediaType string
 	AcceptedFormats  map[string]struct{}
 	CommandPath      string
+	Destinations     config.Destinations
+	ArgPolicy        cmd.ArgPolicy
 }
 
 type TesseractHandler struct {
 	config.Configuration
 	Drupal         drupal.Client
+	Credentials    drupal.Credentials
+	Spooler        *drupal.Spooler
 	CommandBuilder cmd.Builder
 	CommandPath    string
+	Destinations   config.Destinations
+	ArgPolicy      cmd.ArgPolicy
 }
 
 type Pdf2TextHandler struct {
 	config.Configuration
 	Drupal          drupal.Client
+	Credentials     drupal.Credentials
+	Spooler         *drupal.Spooler
 	CommandBuilder  cmd.Builder
 	CommandPath     string
 	AcceptedFormats map[string]struct{}
+	Destinations    config.Destinations
+	ArgPolicy       cmd.ArgPolicy
+}
+
+// FITSHandler produces technical metadata for the source of a message using the File Information Tool Set
+type FITSHandler struct {
+	config.Configuration
+	Drupal           drupal.Client
+	Credentials      drupal.Credentials
+	Spooler          *drupal.Spooler
+	CommandBuilder   cmd.Builder
+	DefaultMediaType string
+	CommandPath      string
+	Destinations     config.Destinations
+	ArgPolicy        cmd.ArgPolicy
 }
 
 type FFMpegHandler struct {
 	config.Configuration
 	Drupal             drupal.Client
+	Credentials        drupal.Credentials
+	Spooler            *drupal.Spooler
 	CommandBuilder     cmd.Builder
 	DefaultMediaType   string
 	AcceptedFormatsMap map[string]string
 	CommandPath        string
+	Destinations       config.Destinations
+	ArgPolicy          cmd.ArgPolicy
 }
 
 func (h *TesseractHandler) Handle(ctx context.Context, t *jwt.Token, b *api.MessageBody) (context.Context, error) {
-	if ctx.Value(api.MsgDestination).(string) != config.HypercubeDestination {
+	if !h.Destinations.Matches(ctx.Value(api.MsgDestination).(string)) {
 		return ctx, nil
 	}
 
+	// reject options supplied by the message that are not permitted, before any command is run
+	if err := h.ArgPolicy.Check(b.Attachment.Content.Args); err != nil {
+		return ctx, err
+	}
+
 	var (
 		// original image from Drupal
 		sourceStream io.ReadCloser
@@ -80,9 +106,9 @@ func (h *TesseractHandler) Handle(ctx context.Context, t *jwt.Token, b *api.Mess
 
 		err error
 
-		logger = newLogger("TesseractHandler", ctx.Value(api.MsgId))
+		logger = logging.FromContext(ctx)
 
-		reqCtx = request.New().WithToken(t)
+		reqCtx = request.New().WithToken(t).WithContext(ctx)
 
 		cmd *exec.Cmd
 	)
@@ -93,25 +119,23 @@ func (h *TesseractHandler) Handle(ctx context.Context, t *jwt.Token, b *api.Mess
 	}
 
 	// Buffer the source stream's first 512 bytes and sniff the content
-	sourceStream, err = h.Drupal.Get(*reqCtx, b.Attachment.Content.SourceUri)
+	if sourceStream, err = h.Drupal.Get(*reqCtx, b.Attachment.Content.SourceUri); err != nil {
+		return ctx, err
+	}
+	defer sourceStream.Close()
 	bufSource := bufio.NewReaderSize(sourceStream, 512)
 
 	if sniff, err := bufSource.Peek(512); err != nil {
 		return ctx, err
 	} else {
 		contentType := http.DetectContentType(sniff)
-		logger.Printf("handler: sniffed media type: %s", contentType)
+		logger.Debug("handler: sniffed media type", "media_type", contentType)
 		if strings.HasPrefix(contentType, "application/pdf") {
 			// then pdf2text handler should handle this
 			return ctx, nil
 		}
 	}
 
-	defer sourceStream.Close()
-	if err != nil {
-		return ctx, err
-	}
-
 	// open tesseract stdin and stdout
 	if tStdin, err = cmd.StdinPipe(); err != nil {
 		return ctx, err
@@ -120,39 +144,31 @@ func (h *TesseractHandler) Handle(ctx context.Context, t *jwt.Token, b *api.Mess
 		return ctx, err
 	}
 
-	stdErr, _ := cmd.StderrPipe()
-	go func() {
-		f, _ := ioutil.TempFile("/tmp", "tesseract-")
-		logger.Printf("handler: ending debug output to '%s'", f.Name())
-		io.Copy(f, stdErr)
-	}()
-
 	go func() {
 		var ioErr error
 		defer func() {
 			tStdin.Close()
 			if ioErr != nil {
-				logger.Printf("handler: error copying stream from '%s' to stdin of '%s': %s",
-					b.Attachment.Content.SourceUri, h.CommandPath, ioErr)
+				logger.Warn("handler: error copying source to stdin", "command", h.CommandPath, logging.FieldError, ioErr)
 			}
 		}()
 		_, ioErr = io.Copy(tStdin, sourceStream)
 	}()
 
-	logger.Printf("handler: running '%s'", cmd.String())
-	if err = cmd.Start(); err != nil {
+	logger.Debug("handler: executing command", "command", cmd.String())
+	var stop func()
+	if stop, err = start(ctx, cmd); err != nil {
 		return ctx, err
 	}
+	defer stop()
 
 	reqCtx.WithHeader("Content-Type", "text/plain").
 		WithHeader("Content-Location", b.Attachment.Content.UploadUri)
-	_, err = h.Drupal.Put(*reqCtx, b.Attachment.Content.DestinationUri, tStdout)
-
-	if err != nil {
+	if err = upload(ctx, h.Drupal, h.Spooler, *reqCtx, b.Attachment.Content.DestinationUri, tStdout, cmd); err != nil {
 		return ctx, err
 	}
 
-	return ctx, cmd.Wait()
+	return api.Handled(ctx, h.Key), nil
 }
 
 func (h *TesseractHandler) Configure(c config.Configuration) error {
@@ -174,8 +190,31 @@ func (h *TesseractHandler) configure(c config.Configuration, ignoreErr bool) err
 		return fmt.Errorf("handler: unable to configure TesseractHandler '%s', parameter '%s': %w", h.Key, "commandPath", err)
 	}
 
+	if h.Destinations, err = config.DestinationsValue(handlerConfig, "destinations", config.HypercubeDestination); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure TesseractHandler '%s', parameter '%s': %w", h.Key, "destinations", err)
+	}
+
+	if h.ArgPolicy, err = configureArgPolicy(handlerConfig); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure TesseractHandler '%s', parameter '%s': %w", h.Key, "args", err)
+	}
+
+	if h.Credentials, err = configureCredentials(handlerConfig); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure TesseractHandler '%s', parameter '%s': %w", h.Key, "credentials", err)
+	}
+
+	if h.Spooler, err = configureSpooler(handlerConfig); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure TesseractHandler '%s', parameter '%s': %w", h.Key, "spool", err)
+	}
+
 	if h.Drupal == nil {
-		h.Drupal = drupal.HttpImpl{HttpClient: drupal.DefaultClient}
+		var client *http.Client
+		if client, err = configureHttpClient(handlerConfig); err != nil && !ignoreErr {
+			return fmt.Errorf("handler: unable to configure TesseractHandler '%s', parameter '%s': %w", h.Key, "http", err)
+		}
+
+		if h.Drupal, err = configureDrupal(handlerConfig, client, h.Credentials); err != nil && !ignoreErr {
+			return fmt.Errorf("handler: unable to configure TesseractHandler '%s', parameter '%s': %w", h.Key, "retry", err)
+		}
 	}
 
 	if h.CommandBuilder == nil {
@@ -186,10 +225,15 @@ func (h *TesseractHandler) configure(c config.Configuration, ignoreErr bool) err
 }
 
 func (h *Pdf2TextHandler) Handle(ctx context.Context, t *jwt.Token, b *api.MessageBody) (context.Context, error) {
-	if ctx.Value(api.MsgDestination).(string) != config.HypercubeDestination {
+	if !h.Destinations.Matches(ctx.Value(api.MsgDestination).(string)) {
 		return ctx, nil
 	}
 
+	// reject options supplied by the message that are not permitted, before any command is run
+	if err := h.ArgPolicy.Check(b.Attachment.Content.Args); err != nil {
+		return ctx, err
+	}
+
 	var (
 		// original image from Drupal
 		sourceStream io.ReadCloser
@@ -200,9 +244,9 @@ func (h *Pdf2TextHandler) Handle(ctx context.Context, t *jwt.Token, b *api.Messa
 
 		err error
 
-		logger = newLogger("Pdf2TextHandler", ctx.Value(api.MsgId))
+		logger = logging.FromContext(ctx)
 
-		reqCtx = request.New().WithToken(t)
+		reqCtx = request.New().WithToken(t).WithContext(ctx)
 
 		cmd *exec.Cmd
 	)
@@ -213,14 +257,17 @@ func (h *Pdf2TextHandler) Handle(ctx context.Context, t *jwt.Token, b *api.Messa
 	}
 
 	// Buffer the source stream's first 512 bytes and sniff the content
-	sourceStream, err = h.Drupal.Get(*reqCtx, b.Attachment.Content.SourceUri)
+	if sourceStream, err = h.Drupal.Get(*reqCtx, b.Attachment.Content.SourceUri); err != nil {
+		return ctx, err
+	}
+	defer sourceStream.Close()
 	bufSource := bufio.NewReaderSize(sourceStream, 512)
 
 	if sniff, err := bufSource.Peek(512); err != nil {
 		return ctx, err
 	} else {
 		contentType := http.DetectContentType(sniff)
-		logger.Printf("handler: sniffed media type: %s", contentType)
+		logger.Debug("handler: sniffed media type", "media_type", contentType)
 		if !strings.HasPrefix(contentType, "application/pdf") {
 			// then tesseract handler should handle this
 			return ctx, nil
@@ -240,26 +287,25 @@ func (h *Pdf2TextHandler) Handle(ctx context.Context, t *jwt.Token, b *api.Messa
 		defer func() {
 			tStdin.Close()
 			if ioErr != nil {
-				logger.Printf("handler: error copying stream from '%s' to stdin of '%s': %s",
-					b.Attachment.Content.SourceUri, h.CommandPath, ioErr)
+				logger.Warn("handler: error copying source to stdin", "command", h.CommandPath, logging.FieldError, ioErr)
 			}
 		}()
 		_, ioErr = io.Copy(tStdin, sourceStream)
 	}()
 
-	if err = cmd.Start(); err != nil {
+	var stop func()
+	if stop, err = start(ctx, cmd); err != nil {
 		return ctx, err
 	}
+	defer stop()
 
 	reqCtx.WithHeader("Content-Type", "text/plain").
 		WithHeader("Content-Location", b.Attachment.Content.UploadUri)
-	_, err = h.Drupal.Put(*reqCtx, b.Attachment.Content.DestinationUri, tStdout)
-
-	if err != nil {
+	if err = upload(ctx, h.Drupal, h.Spooler, *reqCtx, b.Attachment.Content.DestinationUri, tStdout, cmd); err != nil {
 		return ctx, err
 	}
 
-	return ctx, cmd.Wait()
+	return api.Handled(ctx, h.Key), nil
 }
 
 func (h *Pdf2TextHandler) Configure(c config.Configuration) error {
@@ -281,8 +327,31 @@ func (h *Pdf2TextHandler) configure(c config.Configuration, ignoreErr bool) erro
 		return fmt.Errorf("handler: unable to configure Pdf2TextHandler '%s', parameter '%s': %w", h.Key, "commandPath", err)
 	}
 
+	if h.Destinations, err = config.DestinationsValue(handlerConfig, "destinations", config.HypercubeDestination); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure Pdf2TextHandler '%s', parameter '%s': %w", h.Key, "destinations", err)
+	}
+
+	if h.ArgPolicy, err = configureArgPolicy(handlerConfig); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure Pdf2TextHandler '%s', parameter '%s': %w", h.Key, "args", err)
+	}
+
+	if h.Credentials, err = configureCredentials(handlerConfig); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure Pdf2TextHandler '%s', parameter '%s': %w", h.Key, "credentials", err)
+	}
+
+	if h.Spooler, err = configureSpooler(handlerConfig); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure Pdf2TextHandler '%s', parameter '%s': %w", h.Key, "spool", err)
+	}
+
 	if h.Drupal == nil {
-		h.Drupal = drupal.HttpImpl{HttpClient: drupal.DefaultClient}
+		var client *http.Client
+		if client, err = configureHttpClient(handlerConfig); err != nil && !ignoreErr {
+			return fmt.Errorf("handler: unable to configure Pdf2TextHandler '%s', parameter '%s': %w", h.Key, "http", err)
+		}
+
+		if h.Drupal, err = configureDrupal(handlerConfig, client, h.Credentials); err != nil && !ignoreErr {
+			return fmt.Errorf("handler: unable to configure Pdf2TextHandler '%s', parameter '%s': %w", h.Key, "retry", err)
+		}
 	}
 
 	if h.CommandBuilder == nil {
@@ -292,13 +361,17 @@ func (h *Pdf2TextHandler) configure(c config.Configuration, ignoreErr bool) erro
 	return nil
 }
 func (h *ImageMagickHandler) Handle(ctx context.Context, t *jwt.Token, b *api.MessageBody) (context.Context, error) {
-	if ctx.Value(api.MsgDestination).(string) != config.HoudiniDestination {
+	if !h.Destinations.Matches(ctx.Value(api.MsgDestination).(string)) {
 		return ctx, nil
 	}
 
+	// reject options supplied by the message that are not permitted, before any command is run
+	if err := h.ArgPolicy.Check(b.Attachment.Content.Args); err != nil {
+		return ctx, err
+	}
+
 	var (
-		mid    = ctx.Value(api.MsgId)
-		logger = newLogger("ImageMagickHandler", mid)
+		logger = logging.FromContext(ctx)
 		cmd    *exec.Cmd
 		err    error
 	)
@@ -307,14 +380,14 @@ func (h *ImageMagickHandler) Handle(ctx context.Context, t *jwt.Token, b *api.Me
 	defer func() {
 		entries, err := os.ReadDir(os.TempDir())
 		if err != nil {
-			logger.Printf("handler: unable to clean up Imagemagick files '%s'", err)
+			logger.Warn("handler: unable to clean up ImageMagick files", logging.FieldError, err)
 			return
 		}
 
 		for _, entry := range entries {
 			if strings.HasPrefix(entry.Name(), "magick-") {
 				info, _ := entry.Info()
-				logger.Printf("handler: cleaning up Imagemagick temporary file '%s': %v", entry.Name(), info)
+				logger.Debug("handler: found ImageMagick temporary file", "file", entry.Name(), "size", info.Size())
 			}
 		}
 	}()
@@ -326,7 +399,7 @@ func (h *ImageMagickHandler) Handle(ctx context.Context, t *jwt.Token, b *api.Me
 
 	// Map the requested IANA media type to a supported imagemagick output format
 	if _, ok := h.AcceptedFormats[b.Attachment.Content.MimeType]; !ok {
-		return ctx, fmt.Errorf("[%s] [%s] handler: convert does not support mime type '%s'", "ImageMagickHandler", mid, b.Attachment.Content.MimeType)
+		return ctx, fmt.Errorf("handler: '%s' does not support mime type '%s'", h.Key, b.Attachment.Content.MimeType)
 	}
 
 	if cmd, err = h.CommandBuilder.Build(h.CommandPath, t, b); err != nil {
@@ -343,10 +416,8 @@ func (h *ImageMagickHandler) Handle(ctx context.Context, t *jwt.Token, b *api.Me
 		imgStdin io.WriteCloser
 		// imagemagick stdout
 		imgStdout io.ReadCloser
-		// imagemagick stderr
-		imgStderr io.ReadCloser
 
-		reqCtx = request.New().WithToken(t)
+		reqCtx = request.New().WithToken(t).WithContext(ctx)
 	)
 
 	// GET original image from Drupal
@@ -356,16 +427,13 @@ func (h *ImageMagickHandler) Handle(ctx context.Context, t *jwt.Token, b *api.Me
 		return ctx, err
 	}
 
-	// open imagemagick stdin, stdout, and stderr
+	// open imagemagick stdin and stdout
 	if imgStdin, err = cmd.StdinPipe(); err != nil {
 		return ctx, err
 	}
 	if imgStdout, err = cmd.StdoutPipe(); err != nil {
 		return ctx, err
 	}
-	if imgStderr, err = cmd.StderrPipe(); err != nil {
-		return ctx, err
-	}
 
 	// copy the source image to imagemagick's stdin, and close stdin after
 	go func() {
@@ -373,45 +441,29 @@ func (h *ImageMagickHandler) Handle(ctx context.Context, t *jwt.Token, b *api.Me
 		defer func() {
 			imgStdin.Close()
 			if ioErr != nil {
-				logger.Printf("handler: error copying stream from '%s' to stdin of '%s': %s",
-					b.Attachment.Content.SourceUri, h.CommandPath, ioErr)
+				logger.Warn("handler: error copying source to stdin", "command", h.CommandPath, logging.FieldError, ioErr)
 			}
 		}()
 		_, ioErr = io.Copy(imgStdin, sourceStream)
 	}()
 
-	// if there is an error when exiting, attempt to copy out stderr, otherwise close it
-	defer func() {
-		if err != nil {
-			b := &bytes.Buffer{}
-			if _, err := io.Copy(b, imgStderr); err != nil {
-				logger.Printf("handler: there was an error executing ImageMagick, but the stderr could not be captured: '%s'", err)
-			} else {
-				logger.Printf("handler: there was an error executing ImageMagick, stderr follows:\n%s", b)
-			}
-		}
-
-		imgStderr.Close()
-	}()
-
 	// start imagemagick convert
-	logger.Printf("handler: executing %+v\n", cmd)
-	if err := cmd.Start(); err != nil {
+	logger.Debug("handler: executing command", "command", cmd.String())
+	var stop func()
+	if stop, err = start(ctx, cmd); err != nil {
 		return ctx, err
 	}
+	defer stop()
 
 	// PUT the derivative to Drupal, using stdout from imagemagick
 	reqCtx.WithHeader("Content-Location", b.Attachment.Content.UploadUri).
 		WithHeader("Content-Type", b.Attachment.Content.MimeType)
 
-	_, err = h.Drupal.Put(*reqCtx, b.Attachment.Content.DestinationUri, imgStdout)
-
-	if err != nil {
+	if err = upload(ctx, h.Drupal, h.Spooler, *reqCtx, b.Attachment.Content.DestinationUri, imgStdout, cmd); err != nil {
 		return ctx, err
 	}
 
-	// wait for imagemagick convert to finish
-	return ctx, cmd.Wait()
+	return api.Handled(ctx, h.Key), nil
 }
 
 func (h *ImageMagickHandler) Configure(c config.Configuration) error {
@@ -434,6 +486,14 @@ func (h *ImageMagickHandler) configure(c config.Configuration, ignoreErr bool) e
 		return fmt.Errorf("handler: unable to configure ImageMagickHandler '%s', parameter '%s': %w", h.Key, "commandPath", err)
 	}
 
+	if h.Destinations, err = config.DestinationsValue(convertConfig, "destinations", config.HoudiniDestination); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure ImageMagickHandler '%s', parameter '%s': %w", h.Key, "destinations", err)
+	}
+
+	if h.ArgPolicy, err = configureArgPolicy(convertConfig); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure ImageMagickHandler '%s', parameter '%s': %w", h.Key, "args", err)
+	}
+
 	if h.DefaultMediaType, err = config.StringValue(convertConfig, "defaultMediaType"); err != nil && !ignoreErr {
 		return fmt.Errorf("handler: unable to configure ImageMagickHandler '%s', parameter '%s': %w", h.Key, "defaultMediaType", err)
 	}
@@ -448,8 +508,23 @@ func (h *ImageMagickHandler) configure(c config.Configuration, ignoreErr bool) e
 		h.AcceptedFormats[f] = struct{}{}
 	}
 
+	if h.Credentials, err = configureCredentials(convertConfig); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure ImageMagickHandler '%s', parameter '%s': %w", h.Key, "credentials", err)
+	}
+
+	if h.Spooler, err = configureSpooler(convertConfig); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure ImageMagickHandler '%s', parameter '%s': %w", h.Key, "spool", err)
+	}
+
 	if h.Drupal == nil {
-		h.Drupal = drupal.HttpImpl{HttpClient: drupal.DefaultClient}
+		var client *http.Client
+		if client, err = configureHttpClient(convertConfig); err != nil && !ignoreErr {
+			return fmt.Errorf("handler: unable to configure ImageMagickHandler '%s', parameter '%s': %w", h.Key, "http", err)
+		}
+
+		if h.Drupal, err = configureDrupal(convertConfig, client, h.Credentials); err != nil && !ignoreErr {
+			return fmt.Errorf("handler: unable to configure ImageMagickHandler '%s', parameter '%s': %w", h.Key, "retry", err)
+		}
 	}
 
 	if h.CommandBuilder == nil {
@@ -460,11 +535,16 @@ func (h *ImageMagickHandler) configure(c config.Configuration, ignoreErr bool) e
 }
 
 func (h *FFMpegHandler) Handle(ctx context.Context, t *jwt.Token, b *api.MessageBody) (context.Context, error) {
-	if ctx.Value(api.MsgDestination).(string) != config.HomarusDestination {
+	if !h.Destinations.Matches(ctx.Value(api.MsgDestination).(string)) {
 		return ctx, nil
 	}
 
-	logger := newLogger("FFMpegHandler", ctx.Value(api.MsgId))
+	// reject options supplied by the message that are not permitted, before any command is run
+	if err := h.ArgPolicy.Check(b.Attachment.Content.Args); err != nil {
+		return ctx, err
+	}
+
+	logger := logging.FromContext(ctx)
 
 	// Set a default mime type (parity with PHP controller)
 	if b.Attachment.Content.MimeType == "" {
@@ -491,31 +571,23 @@ func (h *FFMpegHandler) Handle(ctx context.Context, t *jwt.Token, b *api.Message
 		return ctx, err
 	}
 
-	//stdErr, _ := cmd.StderrPipe()
-	//go func() {
-	//	f, _ := ioutil.TempFile("/tmp", "ffmpeg-")
-	//	log.Printf("Sending debug output to '%s'", f.Name())
-	//	io.Copy(f, stdErr)
-	//}()
-
 	// start ffmpeg
-	logger.Printf("handler: executing %+v\n", cmd)
-	if err := cmd.Start(); err != nil {
+	logger.Debug("handler: executing command", "command", cmd.String())
+	var stop func()
+	if stop, err = start(ctx, cmd); err != nil {
 		return ctx, err
 	}
+	defer stop()
 
 	// PUT the derivative to Drupal, using stdout from ffmpeg
-	reqCtx := request.New().WithToken(t).
+	reqCtx := request.New().WithToken(t).WithContext(ctx).
 		WithHeader("Content-Location", b.Attachment.Content.UploadUri).
 		WithHeader("Content-Type", b.Attachment.Content.MimeType)
-	_, err = h.Drupal.Put(*reqCtx, b.Attachment.Content.DestinationUri, ffmpegStdout)
-
-	if err != nil {
+	if err = upload(ctx, h.Drupal, h.Spooler, *reqCtx, b.Attachment.Content.DestinationUri, ffmpegStdout, cmd); err != nil {
 		return ctx, err
 	}
 
-	// wait for ffmpeg to finish
-	return ctx, cmd.Wait()
+	return api.Handled(ctx, h.Key), nil
 }
 
 func (h *FFMpegHandler) Configure(c config.Configuration) error {
@@ -538,6 +610,14 @@ func (h *FFMpegHandler) configure(c config.Configuration, ignoreErr bool) error
 		return fmt.Errorf("handler: unable to configure FFMpegHandler '%s', parameter '%s': %w", h.Key, "commandPath", err)
 	}
 
+	if h.Destinations, err = config.DestinationsValue(ffmpegConfig, "destinations", config.HomarusDestination); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure FFMpegHandler '%s', parameter '%s': %w", h.Key, "destinations", err)
+	}
+
+	if h.ArgPolicy, err = configureArgPolicy(ffmpegConfig); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure FFMpegHandler '%s', parameter '%s': %w", h.Key, "args", err)
+	}
+
 	if h.DefaultMediaType, err = config.StringValue(ffmpegConfig, "defaultMediaType"); err != nil && !ignoreErr {
 		return fmt.Errorf("handler: unable to configure FFMpegHandler '%s', parameter '%s': %w", h.Key, "defaultMediaType", err)
 	}
@@ -552,12 +632,155 @@ func (h *FFMpegHandler) configure(c config.Configuration, ignoreErr bool) error
 		h.AcceptedFormatsMap[k] = v.(string)
 	}
 
+	if h.Credentials, err = configureCredentials(ffmpegConfig); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure FFMpegHandler '%s', parameter '%s': %w", h.Key, "credentials", err)
+	}
+
+	if h.Spooler, err = configureSpooler(ffmpegConfig); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure FFMpegHandler '%s', parameter '%s': %w", h.Key, "spool", err)
+	}
+
+	if h.Drupal == nil {
+		var client *http.Client
+		if client, err = configureHttpClient(ffmpegConfig); err != nil && !ignoreErr {
+			return fmt.Errorf("handler: unable to configure FFMpegHandler '%s', parameter '%s': %w", h.Key, "http", err)
+		}
+
+		if h.Drupal, err = configureDrupal(ffmpegConfig, client, h.Credentials); err != nil && !ignoreErr {
+			return fmt.Errorf("handler: unable to configure FFMpegHandler '%s', parameter '%s': %w", h.Key, "retry", err)
+		}
+	}
+
+	if h.CommandBuilder == nil {
+		builder := cmd.FFMpeg{AcceptedFormatsMap: h.AcceptedFormatsMap}
+		if h.Credentials != nil {
+			// ffmpeg retrieves the source itself, so it needs the same credentials as the Drupal client
+			builder.Authorization = h.Credentials.Authorization
+		}
+		h.CommandBuilder = builder
+	}
+
+	return nil
+}
+
+func (h *FITSHandler) Handle(ctx context.Context, t *jwt.Token, b *api.MessageBody) (context.Context, error) {
+	if !h.Destinations.Matches(ctx.Value(api.MsgDestination).(string)) {
+		return ctx, nil
+	}
+
+	// reject options supplied by the message that are not permitted, before any command is run
+	if err := h.ArgPolicy.Check(b.Attachment.Content.Args); err != nil {
+		return ctx, err
+	}
+
+	var (
+		logger = logging.FromContext(ctx)
+		reqCtx = request.New().WithToken(t).WithContext(ctx)
+
+		// local copy of the original file, read by FITS
+		sourceFile string
+		// fits stdout
+		fitsStdout io.ReadCloser
+
+		cmd *exec.Cmd
+		err error
+	)
+
+	// Set a default mime type (parity with PHP controller)
+	if b.Attachment.Content.MimeType == "" {
+		b.Attachment.Content.MimeType = h.DefaultMediaType
+	}
+
+	// GET the original file from Drupal
+	// Copy the original file to the local filesystem, because FITS only reads files
+	// PUT the output of FITS (i.e. the FITS XML) to Drupal
+	if sourceFile, err = localCopy(ctx, h.Drupal, *reqCtx, b.Attachment.Content.SourceUri, "fits-"); err != nil {
+		return ctx, err
+	}
+	defer os.Remove(sourceFile)
+
+	// FITS reads the local copy, the message body is otherwise unchanged
+	localBody := *b
+	localBody.Attachment.Content.SourceUri = sourceFile
+	if cmd, err = h.CommandBuilder.Build(h.CommandPath, t, &localBody); err != nil {
+		return ctx, err
+	}
+
+	// open fits stdout
+	if fitsStdout, err = cmd.StdoutPipe(); err != nil {
+		return ctx, err
+	}
+
+	// start fits
+	logger.Debug("handler: executing command", "command", cmd.String())
+	var stop func()
+	if stop, err = start(ctx, cmd); err != nil {
+		return ctx, err
+	}
+	defer stop()
+
+	// PUT the FITS XML to Drupal, using stdout from fits
+	reqCtx.WithHeader("Content-Location", b.Attachment.Content.UploadUri).
+		WithHeader("Content-Type", b.Attachment.Content.MimeType)
+	if err = upload(ctx, h.Drupal, h.Spooler, *reqCtx, b.Attachment.Content.DestinationUri, fitsStdout, cmd); err != nil {
+		return ctx, err
+	}
+
+	return api.Handled(ctx, h.Key), nil
+}
+
+func (h *FITSHandler) Configure(c config.Configuration) error {
+	return h.configure(c, false)
+}
+
+func (h *FITSHandler) configure(c config.Configuration, ignoreErr bool) error {
+	var (
+		fitsConfig *map[string]interface{}
+		err        error
+	)
+	h.Configuration = c
+
+	if fitsConfig, err = h.UnmarshalHandlerConfig(); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure FITSHandler: %w", err)
+	}
+
+	if h.CommandPath, err = config.StringValue(fitsConfig, "commandPath"); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure FITSHandler '%s', parameter '%s': %w", h.Key, "commandPath", err)
+	}
+
+	if h.Destinations, err = config.DestinationsValue(fitsConfig, "destinations", config.FitsDestination); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure FITSHandler '%s', parameter '%s': %w", h.Key, "destinations", err)
+	}
+
+	if h.ArgPolicy, err = configureArgPolicy(fitsConfig); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure FITSHandler '%s', parameter '%s': %w", h.Key, "args", err)
+	}
+
+	if h.DefaultMediaType, err = config.StringValue(fitsConfig, "defaultMediaType"); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure FITSHandler '%s', parameter '%s': %w", h.Key, "defaultMediaType", err)
+	}
+
+	if h.Credentials, err = configureCredentials(fitsConfig); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure FITSHandler '%s', parameter '%s': %w", h.Key, "credentials", err)
+	}
+
+	if h.Spooler, err = configureSpooler(fitsConfig); err != nil && !ignoreErr {
+		return fmt.Errorf("handler: unable to configure FITSHandler '%s', parameter '%s': %w", h.Key, "spool", err)
+	}
+
 	if h.Drupal == nil {
-		h.Drupal = drupal.HttpImpl{HttpClient: drupal.DefaultClient}
+		var client *http.Client
+		if client, err = configureHttpClient(fitsConfig); err != nil && !ignoreErr {
+			return fmt.Errorf("handler: unable to configure FITSHandler '%s', parameter '%s': %w", h.Key, "http", err)
+		}
+
+		if h.Drupal, err = configureDrupal(fitsConfig, client, h.Credentials); err != nil && !ignoreErr {
+			return fmt.Errorf("handler: unable to configure FITSHandler '%s', parameter '%s': %w", h.Key, "retry", err)
+		}
 	}
 
 	if h.CommandBuilder == nil {
-		h.CommandBuilder = cmd.FFMpeg{AcceptedFormatsMap: h.AcceptedFormatsMap}
+		h.CommandBuilder = cmd.FITS{}
 	}
 
 	return nil
@@ -579,41 +802,59 @@ type JWTHandler struct {
 	config.Configuration
 	RejectIfTokenMissing bool `json:"requireTokens"`
 	VerifyTokens         bool `json:"verifyTokens"`
+	// Issuer, if not empty, must equal the 'iss' claim of a token
+	Issuer string `json:"issuer"`
+	// Audience, if not empty, must contain one of the audiences in the 'aud' claim of a token
+	Audience []string `json:"audience"`
+	// Leeway is the clock skew allowed when checking the 'exp' and 'nbf' claims of a token
+	Leeway time.Duration `json:"leeway"`
+	// Keys verify the signatures of tokens when VerifyTokens is true
+	Keys *KeySet
+	// Minter, if not nil, replaces tokens that are expired or absent with a service account token
+	Minter *TokenMinter
 }
 
 type JWTLoggingHandler struct {
 	config.Configuration
+	// Keys verify the signatures of the tokens that are logged, if nil signatures are not verified
+	Keys *KeySet
 }
 
 func (h *JWTLoggingHandler) Configure(c config.Configuration) error {
-	// no-op
+	// the logging handler only uses the keys from the environment, and does not fail if they are unusable
+	h.Keys = &KeySet{}
+	if err := h.Keys.Load(); err != nil {
+		logging.Default().Warn("handler: JWTLoggingHandler will not verify JWTs", logging.FieldHandler, c.Key, logging.FieldError, err)
+		h.Keys = nil
+	}
 	return nil
 }
 
 func (h *JWTLoggingHandler) Handle(ctx context.Context, t *jwt.Token, b *api.MessageBody) (context.Context, error) {
-	// TODO: handle a nil token, congruent with handler configuration
-	var err error
-	privateKey := []byte(env.GetOrDefault(config.VarDrupalJwtPrivateKey, ""))
-	publicKey := []byte(env.GetOrDefault(config.VarDrupalJwtPublicKey, ""))
-	logger := newLogger("JWTLoggingHandler", ctx.Value(api.MsgId))
+	logger := logging.FromContext(ctx)
 
-	err = verify(t, privateKey, publicKey)
+	// whether a missing token is acceptable is decided by the JWTHandler, there is simply nothing to log
+	if t == nil {
+		logger.Info("handler: message does not carry a JWT")
+		return ctx, nil
+	}
 
-	if err != nil {
-		logger.Printf("handler: JWT could not be verified: %s", err)
+	if h.Keys == nil {
+		logger.Info("handler: JWT not verified, no keys are configured")
+	} else if err := h.Keys.Verify(ctx, t); err != nil {
+		logger.Info("handler: JWT could not be verified", logging.FieldError, err)
 	} else {
-		logger.Printf("handler: JWT verified")
+		logger.Info("handler: JWT verified")
 	}
 
 	// Decode all claims and log them
 	claims := make(map[string]interface{})
 	if err := t.DecodeClaims(&claims); err != nil {
-		logger.Printf("handler: error decoding JWT claims: %s", err)
+		logger.Warn("handler: error decoding JWT claims", logging.FieldError, err)
 	}
 
+	// times are logged in a readable form, as well as their numeric value
 	expired := time.Time{}
-	builder := strings.Builder{}
-	builder.WriteString("JWT claims:\n")
 	for k, v := range claims {
 		switch k {
 		case "exp":
@@ -621,135 +862,114 @@ func (h *JWTLoggingHandler) Handle(ctx context.Context, t *jwt.Token, b *api.Mes
 			if time.Now().After(expTime) {
 				expired = expTime
 			}
-			builder.WriteString(fmt.Sprintf("  %s: %v (%s)\n", k, v, expTime.Format(time.RFC3339)))
+			claims[k] = fmt.Sprintf("%v (%s)", v, expTime.Format(time.RFC3339))
 		case "iat":
-			builder.WriteString(fmt.Sprintf("  %s: %v (%s)\n", k, v, time.Unix(int64(v.(float64)), 0).Format(time.RFC3339)))
-		default:
-			builder.WriteString(fmt.Sprintf("  %s: %v\n", k, v))
+			claims[k] = fmt.Sprintf("%v (%s)", v, time.Unix(int64(v.(float64)), 0).Format(time.RFC3339))
 		}
 	}
 
-	logger.Printf("handler: %s", builder.String())
+	logger.Info("handler: JWT claims", "claims", claims)
 	if !expired.IsZero() {
-		logger.Printf("handler: JWT expired at %s", expired.Format(time.RFC3339))
+		logger.Info("handler: JWT expired", "expired_at", expired.Format(time.RFC3339))
 	}
 
 	return ctx, nil
 }
 
+// Handle applies the token policy of the handler.  A message without a token is rejected if RejectIfTokenMissing is
+// true, otherwise it is passed to the remaining handlers without a token.  The signature of a token is verified if
+// VerifyTokens is true.
 func (h *JWTHandler) Handle(ctx context.Context, t *jwt.Token, m *api.MessageBody) (context.Context, error) {
-	// TODO: handle a nil token, congruent with handler configuration
+	logger := logging.FromContext(ctx)
 
-	// FIXME: we don't need a public key for RS256, and we may not need a "private" key for other algorithms.
-	//  Figure out appropriate variable names, but we shouldn't panic until we know what keys are needed from the
-	//  environment
-	var publicKey = []byte(env.GetOrDefault(config.VarDrupalJwtPublicKey, ""))
-	var privateKey = []byte(env.GetOrDefault(config.VarDrupalJwtPrivateKey, ""))
-	var err error
-	logger := newLogger("JWTHandler", ctx.Value(api.MsgId))
+	if t == nil {
+		if h.Minter != nil {
+			return h.mint(ctx, logger, "message does not carry a JWT")
+		}
+		if h.RejectIfTokenMissing {
+			return ctx, fmt.Errorf("handler: %w: JWT is required, but message-id %s does not carry one", api.TokenMissingErr, ctx.Value(api.MsgId))
+		}
+		logger.Info("handler: message does not carry a JWT, continuing without one")
+		return ctx, nil
+	}
 
-	err = verify(t, privateKey, publicKey)
+	if h.VerifyTokens {
+		if h.Keys == nil {
+			return ctx, fmt.Errorf("handler: unable to verify JWT for message-id %s: no keys are configured", ctx.Value(api.MsgId))
+		}
 
-	if err != nil {
-		return ctx, fmt.Errorf("handler: unable to verify JWT for message-id %s: %w",
-			ctx.Value(api.MsgId), err)
+		if err := h.Keys.Verify(ctx, t); err != nil {
+			return ctx, fmt.Errorf("handler: unable to verify JWT for message-id %s: %w",
+				ctx.Value(api.MsgId), err)
+		}
 	}
 
-	// Decode registered claims and check expiration
+	// Decode registered claims and check them
 	rClaims := jwt.RegisteredClaims{}
 
-	if err := t.DecodeClaims(&jwt.RegisteredClaims{}); err != nil {
-		return ctx, fmt.Errorf("handler: error decoding JWT claims for message-id '%s': %w", ctx.Value(api.MsgId), err)
-	} else if !rClaims.IsValidExpiresAt(time.Now()) {
-		return ctx, fmt.Errorf("handler: JWT for message-id %s is expired on %s", ctx.Value(api.MsgId), rClaims.ExpiresAt.Format(time.RFC3339))
+	if err := t.DecodeClaims(&rClaims); err != nil {
+		return ctx, fmt.Errorf("handler: %w: error decoding JWT claims for message-id '%s': %s", api.TokenInvalidErr, ctx.Value(api.MsgId), err)
+	}
+
+	if err := h.validateClaims(rClaims, time.Now()); err != nil {
+		// only a genuine token that has outlived its lifetime is replaced, other problems are not papered over
+		if h.Minter != nil && errors.Is(err, api.TokenExpiredErr) {
+			return h.mint(ctx, logger, err.Error())
+		}
+		return ctx, fmt.Errorf("handler: unable to accept JWT for message-id %s: %w", ctx.Value(api.MsgId), err)
 	}
 
 	ctx = context.WithValue(ctx, api.MsgJwt, t)
 
-	logger.Printf("handle: verified JWT for message %s", ctx.Value(api.MsgId))
+	if h.VerifyTokens {
+		logger.Debug("handler: verified JWT")
+	} else {
+		logger.Debug("handler: accepted JWT without verifying its signature")
+	}
 	return ctx, nil
 }
 
-func verify(token *jwt.Token, privateKey, publicKey []byte) error {
-
-	var (
-		verifier jwt.Verifier
-		err      error
-	)
+// mint replaces the token of the message with a service account token
+func (h *JWTHandler) mint(ctx context.Context, logger *logging.Logger, reason string) (context.Context, error) {
+	t, err := h.Minter.Mint(time.Now())
+	if err != nil {
+		return ctx, fmt.Errorf("handler: unable to replace JWT for message-id %s: %w", ctx.Value(api.MsgId), err)
+	}
 
-	switch token.Header().Algorithm {
-	case jwt.EdDSA:
-		verifier, err = jwt.NewVerifierEdDSA(publicKey)
-
-	case jwt.HS256:
-		fallthrough
-	case jwt.HS384:
-		fallthrough
-	case jwt.HS512:
-		// TODO
-		verifier, err = jwt.NewVerifierHS(token.Header().Algorithm, privateKey)
-
-	case jwt.RS256:
-		fallthrough
-	case jwt.RS384:
-		fallthrough
-	case jwt.RS512:
-		block, _ := pem.Decode(publicKey)
-		blockType := "RSA PUBLIC KEY"
-		if block == nil || block.Type != blockType {
-			return fmt.Errorf("handler: unable to verify JWT, invalid PEM encoded %s", blockType)
-		}
-		if pubKey, err := x509.ParsePKIXPublicKey(block.Bytes); err != nil {
-			return fmt.Errorf("handler: unable to verify JWT, invalid PEM encoded %s: %w", blockType, err)
-		} else {
-			if rsaKey, ok := pubKey.(*rsa.PublicKey); ok {
-				verifier, err = jwt.NewVerifierRS(token.Header().Algorithm, rsaKey)
-			} else {
-				return fmt.Errorf("handler: unable to verify JWT, need %T but got %T", &rsa.PublicKey{}, pubKey)
-			}
-		}
+	logger.Info("handler: using a service account JWT", "reason", reason)
+	return context.WithValue(ctx, api.MsgJwt, t), nil
+}
 
-	case jwt.ES256:
-		fallthrough
-	case jwt.ES384:
-		fallthrough
-	case jwt.ES512:
-		block, _ := pem.Decode(privateKey)
-		blockType := "EC PRIVATE KEY"
-		if block == nil || block.Type != blockType {
-			return fmt.Errorf("handler: unable to verify JWT, invalid PEM encoded %s", blockType)
-		}
-		if key, err := x509.ParseECPrivateKey(block.Bytes); err != nil {
-			return fmt.Errorf("handler: unable to verify JWT, invalid PEM encoded %s: %w", blockType, err)
-		} else {
-			verifier, err = jwt.NewVerifierES(token.Header().Algorithm, &key.PublicKey)
-		}
+// validateClaims checks the expiry, not-before, issuer, and audience claims of a token, answering an error wrapping
+// one of the api.Token*Err errors if a claim is not acceptable.  The expiry and not-before times are checked with
+// h.Leeway of clock skew.
+func (h *JWTHandler) validateClaims(claims jwt.RegisteredClaims, now time.Time) error {
+	if !claims.IsValidExpiresAt(now.Add(-h.Leeway)) {
+		return fmt.Errorf("handler: %w: expired on %s", api.TokenExpiredErr, claims.ExpiresAt.Format(time.RFC3339))
+	}
 
-	case jwt.PS256:
-		fallthrough
-	case jwt.PS384:
-		fallthrough
-	case jwt.PS512:
-		block, _ := pem.Decode(publicKey)
-		blockType := "RSA PUBLIC KEY"
-		if block == nil || block.Type != blockType {
-			return fmt.Errorf("handler: unable to verify JWT, invalid PEM encoded %s", blockType)
-		}
-		if pubKey, err := x509.ParsePKCS1PublicKey(block.Bytes); err != nil {
-			return fmt.Errorf("handler: unable to verify JWT, invalid PEM encoded %s: %w", blockType, err)
-		} else {
-			verifier, err = jwt.NewVerifierPS(token.Header().Algorithm, pubKey)
-		}
+	if !claims.IsValidNotBefore(now.Add(h.Leeway)) {
+		return fmt.Errorf("handler: %w: not valid before %s", api.TokenNotYetValidErr, claims.NotBefore.Format(time.RFC3339))
+	}
 
-	default:
-		return fmt.Errorf("handler: unknown or unsupported JWT algorithm '%s'", token.Header().Algorithm)
+	if h.Issuer != "" && !claims.IsIssuer(h.Issuer) {
+		return fmt.Errorf("handler: %w: issued by '%s', expected '%s'", api.TokenIssuerErr, claims.Issuer, h.Issuer)
 	}
 
-	if err != nil {
-		return fmt.Errorf("handler: unable instantiate JWT Verifier: %w", err)
+	if len(h.Audience) > 0 {
+		accepted := false
+		for _, audience := range h.Audience {
+			if claims.IsForAudience(audience) {
+				accepted = true
+				break
+			}
+		}
+		if !accepted {
+			return fmt.Errorf("handler: %w: intended for %v, expected one of %v", api.TokenAudienceErr, claims.Audience, h.Audience)
+		}
 	}
 
-	return verifier.Verify(token)
+	return nil
 }
 
 func (h *JWTHandler) Configure(c config.Configuration) error {
@@ -775,15 +995,74 @@ func (h *JWTHandler) Configure(c config.Configuration) error {
 		h.VerifyTokens = verifyTokens
 	}
 
+	// the remaining parameters are optional
+	if h.Issuer, err = config.StringValue(jwtConfig, "issuer"); err != nil && !errors.Is(err, config.NotFoundErr) {
+		return fmt.Errorf("handler: unable to configure JWTHandler '%s', parameter '%s': %w", h.Key, "issuer", err)
+	}
+
+	if h.Audience, err = config.SliceStringValue(jwtConfig, "audience"); err != nil && !errors.Is(err, config.NotFoundErr) {
+		return fmt.Errorf("handler: unable to configure JWTHandler '%s', parameter '%s': %w", h.Key, "audience", err)
+	}
+
+	if h.Leeway, err = config.DurationValue(jwtConfig, "leeway"); err != nil && !errors.Is(err, config.NotFoundErr) {
+		return fmt.Errorf("handler: unable to configure JWTHandler '%s', parameter '%s': %w", h.Key, "leeway", err)
+	}
+
+	h.Keys = &KeySet{}
+
+	if h.Keys.PemFiles, err = config.SliceStringValue(jwtConfig, "keyFiles"); err != nil && !errors.Is(err, config.NotFoundErr) {
+		return fmt.Errorf("handler: unable to configure JWTHandler '%s', parameter '%s': %w", h.Key, "keyFiles", err)
+	}
+
+	if h.Keys.Jwks, err = config.SliceStringValue(jwtConfig, "jwks"); err != nil && !errors.Is(err, config.NotFoundErr) {
+		return fmt.Errorf("handler: unable to configure JWTHandler '%s', parameter '%s': %w", h.Key, "jwks", err)
+	}
+
+	if h.Keys.RefreshInterval, err = config.DurationValue(jwtConfig, "keyRefreshInterval"); err != nil && !errors.Is(err, config.NotFoundErr) {
+		return fmt.Errorf("handler: unable to configure JWTHandler '%s', parameter '%s': %w", h.Key, "keyRefreshInterval", err)
+	}
+
+	if serviceAccount, err := config.MapValue(jwtConfig, "serviceAccount"); err == nil {
+		if h.Minter, err = configureMinter(&serviceAccount); err != nil {
+			return fmt.Errorf("handler: unable to configure JWTHandler '%s', parameter '%s': %w", h.Key, "serviceAccount", err)
+		}
+	} else if !errors.Is(err, config.NotFoundErr) {
+		return fmt.Errorf("handler: unable to configure JWTHandler '%s', parameter '%s': %w", h.Key, "serviceAccount", err)
+	}
+
+	if h.VerifyTokens {
+		if err = h.Keys.Load(); err != nil {
+			return fmt.Errorf("handler: unable to configure JWTHandler '%s': %w", h.Key, err)
+		}
+		if h.Keys.Len() == 0 {
+			return fmt.Errorf("handler: unable to configure JWTHandler '%s': verifyTokens is true, but no keys are configured", h.Key)
+		}
+	}
+
 	return nil
 }
 
-func newLogger(handlerName string, messageId interface{}) *log.Logger {
-	return newLoggerWithPrefix(fmt.Sprintf("[%s] [%s] ", handlerName, messageId))
-}
+// configureArgPolicy answers the cmd.ArgPolicy configured by the optional 'args' parameter of a handler, which may
+// contain the options that messages are permitted to supply ('allow') and those that are not ('deny').
+func configureArgPolicy(handlerConfig *map[string]interface{}) (cmd.ArgPolicy, error) {
+	var policy cmd.ArgPolicy
+
+	argsConfig, err := config.MapValue(handlerConfig, "args")
+	if errors.Is(err, config.NotFoundErr) {
+		return policy, nil
+	} else if err != nil {
+		return policy, err
+	}
+
+	if policy.Allow, err = config.SliceStringValue(&argsConfig, "allow"); err != nil && !errors.Is(err, config.NotFoundErr) {
+		return policy, err
+	}
+
+	if policy.Deny, err = config.SliceStringValue(&argsConfig, "deny"); err != nil && !errors.Is(err, config.NotFoundErr) {
+		return policy, err
+	}
 
-func newLoggerWithPrefix(prefix string) *log.Logger {
-	return log.New(log.Writer(), prefix, log.Flags())
+	return policy, nil
 }
 
 func asBearer(token *jwt.Token) string {
@@ -1,14 +1,26 @@
 package handler
 
 import (
+	"context"
 	"derivative-ms/api"
+	"derivative-ms/cmd"
 	"derivative-ms/config"
+	"derivative-ms/drupal"
+	"derivative-ms/drupal/request"
+	"errors"
+	"github.com/cristalhq/jwt/v4"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"io"
 	"io/fs"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 var imDefaultConfig = map[string]interface{}{
@@ -39,6 +51,10 @@ var tesseractDefaultConfig = map[string]interface{}{}
 
 var pdf2TextDefaultConfig = map[string]interface{}{}
 
+var fitsDefaultConfig = map[string]interface{}{
+	"defaultMediaType": "application/xml",
+}
+
 func Test_ImageMagick_Suite(t *testing.T) {
 	suite, _ := newImageMagickSuite()
 	require.Nil(t, suite.handler.configure(suite.configuration, true))
@@ -74,6 +90,57 @@ func Test_Tesseract_Suite(t *testing.T) {
 	t.Run("ExecOk", testExecOk(mutableHandler(suite), &suite.suite))
 }
 
+func Test_SniffingHandlersGetError(t *testing.T) {
+	statusErr := &drupal.StatusError{Method: http.MethodGet, Uri: "http://example.org/moo", Code: 404}
+
+	// the error of the GET is answered as-is, rather than sniffing a nil or closed body
+	tesseract, _ := newTesseractSuite()
+	require.Nil(t, tesseract.handler.configure(tesseract.configuration, true))
+	tesseract.drupalClient.get.retErr = statusErr
+	_, err := tesseract.handler.Handle(tesseract.ctx.ctx, nil, &api.MessageBody{})
+	assert.Equal(t, statusErr, err)
+
+	pdf2Text, _ := newPdf2TextSuite()
+	require.Nil(t, pdf2Text.handler.configure(pdf2Text.configuration, true))
+	pdf2Text.drupalClient.get.retErr = context.DeadlineExceeded
+	_, err = pdf2Text.handler.Handle(pdf2Text.ctx.ctx, nil, &api.MessageBody{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// closeRecorder records whether the body it wraps was closed
+type closeRecorder struct {
+	io.ReadCloser
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return c.ReadCloser.Close()
+}
+
+func Test_SniffingHandlersCloseSource(t *testing.T) {
+	// each handler passes on the sources sniffed as belonging to the other, which must still be closed
+	tesseract, _ := newTesseractSuite()
+	require.Nil(t, tesseract.handler.configure(tesseract.configuration, true))
+	pdf, err := os.Open("testdata/magic-pdf-bytes.bin")
+	require.Nil(t, err)
+	body := &closeRecorder{ReadCloser: pdf}
+	tesseract.drupalClient.get.retBody = body
+	_, err = tesseract.handler.Handle(tesseract.ctx.ctx, nil, &api.MessageBody{})
+	assert.Nil(t, err)
+	assert.True(t, body.closed)
+
+	pdf2Text, _ := newPdf2TextSuite()
+	require.Nil(t, pdf2Text.handler.configure(pdf2Text.configuration, true))
+	tif, err := os.Open("testdata/magic-tif-bytes.bin")
+	require.Nil(t, err)
+	body = &closeRecorder{ReadCloser: tif}
+	pdf2Text.drupalClient.get.retBody = body
+	_, err = pdf2Text.handler.Handle(pdf2Text.ctx.ctx, nil, &api.MessageBody{})
+	assert.Nil(t, err)
+	assert.True(t, body.closed)
+}
+
 func Test_Pdf2Text_Suite(t *testing.T) {
 	suite, _ := newPdf2TextSuite()
 	require.Nil(t, suite.handler.configure(suite.configuration, true))
@@ -87,6 +154,56 @@ func Test_Pdf2Text_Suite(t *testing.T) {
 	t.Run("ExecOk", testExecOk(mutableHandler(suite), &suite.suite))
 }
 
+func Test_FITS_Suite(t *testing.T) {
+	suite, _ := newFitsSuite()
+	require.Nil(t, suite.handler.configure(suite.configuration, true))
+	t.Run("CommandNotFound", testCommandNotFound(mutableHandler(suite), &suite.suite))
+
+	// Reset suite state between tests
+	suite, _ = newFitsSuite()
+	require.Nil(t, suite.handler.configure(suite.configuration, true))
+	t.Run("ExecOk", testExecOk(mutableHandler(suite), &suite.suite))
+}
+
+// builderFunc adapts a function to the cmd.Builder interface
+type builderFunc func(commandPath string, token *jwt.Token, body *api.MessageBody) (*exec.Cmd, error)
+
+func (f builderFunc) Build(commandPath string, token *jwt.Token, body *api.MessageBody) (*exec.Cmd, error) {
+	return f(commandPath, token, body)
+}
+
+func Test_FITSLocalCopy(t *testing.T) {
+	suite, _ := newFitsSuite()
+	require.Nil(t, suite.handler.configure(suite.configuration, true))
+	suite.drupalClient.get.retBody = ioutil.NopCloser(strings.NewReader("moo"))
+
+	// the command reads the local copy of the source, and writes it to stdout
+	catPath, err := exec.LookPath("cat")
+	require.Nil(t, err)
+	var localCopy string
+	suite.handler.CommandBuilder = builderFunc(func(commandPath string, token *jwt.Token, body *api.MessageBody) (*exec.Cmd, error) {
+		localCopy = body.Attachment.Content.SourceUri
+		return &exec.Cmd{Path: catPath, Args: []string{catPath, localCopy}}, nil
+	})
+
+	b := &api.MessageBody{}
+	b.Attachment.Content.SourceUri = "http://example.org/moo.tif"
+	b.Attachment.Content.DestinationUri = "http://example.org/moo.xml"
+
+	ctx, err := suite.handler.Handle(suite.ctx.ctx, nil, b)
+	assert.Nil(t, err)
+	assert.Len(t, api.HandledBy(ctx), 1)
+
+	assert.Equal(t, "http://example.org/moo.tif", suite.drupalClient.get.uri)
+	assert.Equal(t, "http://example.org/moo.tif", b.Attachment.Content.SourceUri, "the message body must not be modified")
+	assert.Equal(t, ".tif", filepath.Ext(localCopy))
+	assert.Equal(t, []byte("moo"), suite.drupalClient.put.body)
+	assert.Equal(t, "application/xml", suite.drupalClient.put.reqCtx.Headers()["Content-Type"])
+
+	_, err = os.Stat(localCopy)
+	assert.ErrorIs(t, err, fs.ErrNotExist, "the local copy must be removed")
+}
+
 func testExecOk(h mutableHandler, s *suite) func(*testing.T) {
 	return func(t *testing.T) {
 		echoPath, err := exec.LookPath("echo")
@@ -96,9 +213,10 @@ func testExecOk(h mutableHandler, s *suite) func(*testing.T) {
 			Args: []string{echoPath, "hello world"},
 		}})
 
-		_, err = h.Handle(s.ctx.ctx, nil, &api.MessageBody{})
+		ctx, err := h.Handle(s.ctx.ctx, nil, &api.MessageBody{})
 		assert.Nil(t, err)
 		assert.Equal(t, []byte("hello world\n"), s.drupalClient.put.body)
+		assert.Len(t, api.HandledBy(ctx), 1, "expected the handler to claim the message")
 	}
 }
 
@@ -211,6 +329,31 @@ func newPdf2TextSuite() (*pdf2TextSuite, *mockDrupal) {
 	}, d
 }
 
+func newFitsSuite() (*fitsSuite, *mockDrupal) {
+	destination := config.FitsDestination
+	configKey := "fitsTest"
+	handlerConfig := fitsDefaultConfig
+	messageBody := api.MessageBody{}
+
+	c := config.Configuration{
+		Key: configKey,
+		Config: &config.Config{
+			Json: map[string]interface{}{
+				configKey: handlerConfig,
+			},
+		},
+	}
+
+	s, d := newSuite(newContext("moo-msg-id", destination, messageBody), c)
+	return &fitsSuite{
+		suite: *s,
+		handler: &FITSHandler{
+			Configuration: c,
+			Drupal:        d,
+		},
+	}, d
+}
+
 func newSuite(ctx ctxStruct, c config.Configuration) (*suite, *mockDrupal) {
 	drupalClient := &mockDrupal{}
 	return &suite{
@@ -222,3 +365,168 @@ func newSuite(ctx ctxStruct, c config.Configuration) (*suite, *mockDrupal) {
 		drupalClient: drupalClient,
 	}, drupalClient
 }
+
+func Test_ConfiguredDestinations(t *testing.T) {
+	suite, _ := newImageMagickSuite()
+	suite.configuration.Json[suite.configuration.Key] = map[string]interface{}{
+		"defaultMediaType": "image/jpeg",
+		"acceptedFormats":  []string{"image/jpeg"},
+		"destinations":     []interface{}{"/queue/moo-*"},
+	}
+	require.Nil(t, suite.handler.configure(suite.configuration, true))
+	assert.Equal(t, config.Destinations{"/queue/moo-*"}, suite.handler.Destinations)
+
+	// the suite's message is sent to the default Houdini destination, which the handler is no longer configured for
+	ctx, err := suite.handler.Handle(suite.ctx.ctx, nil, &api.MessageBody{})
+	assert.Nil(t, err)
+	assert.Empty(t, api.HandledBy(ctx))
+	assert.Equal(t, "", suite.drupalClient.get.uri)
+}
+
+func Test_ConfiguredCredentials(t *testing.T) {
+	t.Setenv("DRUPAL_TEST_PASSWORD", "foo")
+	suite, _ := newFFMpegSuite()
+	handlerConfig := map[string]interface{}{
+		"commandPath": "ffmpeg",
+		"credentials": map[string]interface{}{
+			"type":        "basic",
+			"username":    "moo",
+			"passwordEnv": "DRUPAL_TEST_PASSWORD",
+		},
+	}
+	for k, v := range ffmpegDefaultConfig {
+		handlerConfig[k] = v
+	}
+	suite.configuration.Json[suite.configuration.Key] = handlerConfig
+	suite.handler.CommandBuilder = nil
+	require.Nil(t, suite.handler.configure(suite.configuration, false))
+	assert.Equal(t, drupal.BasicAuthCredentials{User: "moo", Pass: "foo"}, suite.handler.Credentials)
+
+	// ffmpeg retrieves the source with the same credentials
+	b := &api.MessageBody{}
+	b.Attachment.Content.MimeType = "video/mp4"
+	c, err := suite.handler.CommandBuilder.Build("ffmpeg", nil, b)
+	require.Nil(t, err)
+	assert.Contains(t, c.Args, "Authorization: Basic bW9vOmZvbw==")
+
+	// unknown credential types are rejected
+	handlerConfig["credentials"] = map[string]interface{}{"type": "moo"}
+	assert.NotNil(t, suite.handler.configure(suite.configuration, false))
+}
+
+func Test_SpooledUpload(t *testing.T) {
+	suite, _ := newImageMagickSuite()
+	dir := t.TempDir()
+	handlerConfig := map[string]interface{}{
+		"commandPath": "convert",
+		"spool":       map[string]interface{}{"memoryThreshold": 4, "maxSize": 1024, "dir": dir},
+	}
+	for k, v := range imDefaultConfig {
+		handlerConfig[k] = v
+	}
+	suite.configuration.Json[suite.configuration.Key] = handlerConfig
+	require.Nil(t, suite.handler.configure(suite.configuration, false))
+	require.Equal(t, &drupal.Spooler{MemoryThreshold: 4, MaxSize: 1024, Dir: dir}, suite.handler.Spooler)
+
+	t.Run("ExecOk", testExecOk(mutableHandler(suite), &suite.suite))
+
+	entries, err := os.ReadDir(dir)
+	require.Nil(t, err)
+	assert.Empty(t, entries, "the spooled output must be removed")
+
+	// the output of a command that fails is not uploaded
+	falsePath, err := exec.LookPath("false")
+	require.Nil(t, err)
+	suite.drupalClient.put.uri, suite.drupalClient.put.body = "", nil
+	suite.handler.CommandBuilder = &mockCmd{cmd: &exec.Cmd{Path: falsePath, Args: []string{falsePath}}}
+	_, err = suite.handler.Handle(suite.ctx.ctx, nil, &api.MessageBody{})
+	assert.NotNil(t, err)
+	assert.Nil(t, suite.drupalClient.put.body)
+}
+
+// failingPut is a drupal.Client whose uploads fail without reading the body
+type failingPut struct {
+	mockDrupal
+}
+
+func (*failingPut) Put(reqCtx request.Context, uri string, body io.ReadCloser) (int, error) {
+	return -1, errors.New("moo")
+}
+
+func Test_UploadPutFails(t *testing.T) {
+	yesPath, err := exec.LookPath("yes")
+	require.Nil(t, err)
+
+	// yes writes to stdout until it is killed, so it is left blocked on a full pipe unless upload kills it
+	c := exec.Command(yesPath)
+	stdout, err := c.StdoutPipe()
+	require.Nil(t, err)
+	stop, err := start(context.Background(), c)
+	require.Nil(t, err)
+	defer stop()
+
+	err = upload(context.Background(), &failingPut{}, nil, *request.New(), "http://example.org/moo", stdout, c)
+	assert.EqualError(t, err, "moo")
+	assert.NotNil(t, c.ProcessState, "the command must be killed and waited for")
+}
+
+func Test_ConfiguredHttpClient(t *testing.T) {
+	suite, _ := newImageMagickSuite()
+	handlerConfig := map[string]interface{}{
+		"commandPath": "convert",
+		"http": map[string]interface{}{
+			"timeout":             "5m",
+			"maxIdleConnsPerHost": 4,
+		},
+	}
+	for k, v := range imDefaultConfig {
+		handlerConfig[k] = v
+	}
+	suite.configuration.Json[suite.configuration.Key] = handlerConfig
+
+	suite.handler.Drupal = nil
+	require.Nil(t, suite.handler.configure(suite.configuration, false))
+	httpClient := suite.handler.Drupal.(drupal.RetryingClient).Client.(drupal.HttpImpl).HttpClient
+	assert.Equal(t, 5*time.Minute, httpClient.Timeout)
+	assert.Equal(t, 4, httpClient.Transport.(*http.Transport).MaxIdleConnsPerHost)
+
+	// without the parameter, the default client is shared
+	delete(handlerConfig, "http")
+	suite.handler.Drupal = nil
+	require.Nil(t, suite.handler.configure(suite.configuration, false))
+	assert.Same(t, drupal.DefaultClient, suite.handler.Drupal.(drupal.RetryingClient).Client.(drupal.HttpImpl).HttpClient)
+
+	// an unreadable CA bundle is an error
+	handlerConfig["http"] = map[string]interface{}{"caBundle": filepath.Join(t.TempDir(), "moo.pem")}
+	suite.handler.Drupal = nil
+	assert.NotNil(t, suite.handler.configure(suite.configuration, false))
+}
+
+func Test_ConfiguredArgPolicy(t *testing.T) {
+	suite, _ := newImageMagickSuite()
+	handlerConfig := map[string]interface{}{
+		"commandPath": "convert",
+		"args":        map[string]interface{}{"deny": []interface{}{"-write"}},
+	}
+	for k, v := range imDefaultConfig {
+		handlerConfig[k] = v
+	}
+	suite.configuration.Json[suite.configuration.Key] = handlerConfig
+	suite.handler.CommandBuilder = nil
+	require.Nil(t, suite.handler.configure(suite.configuration, false))
+	assert.Equal(t, []string{"-write"}, suite.handler.ArgPolicy.Deny)
+
+	// the message is rejected before the source is retrieved
+	b := &api.MessageBody{}
+	b.Attachment.Content.Args = "-thumbnail 100x100 -write /tmp/moo.jpg"
+	_, err := suite.handler.Handle(suite.ctx.ctx, nil, b)
+	assert.ErrorIs(t, err, cmd.ArgsPolicyErr)
+	assert.Equal(t, "", suite.drupalClient.get.uri)
+
+	// quoted arguments are preserved
+	b.Attachment.Content.Args = "-annotate +10+10 'moo cow'"
+	b.Attachment.Content.MimeType = "image/png"
+	c, err := suite.handler.CommandBuilder.Build("convert", nil, b)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"convert", "-", "-annotate", "+10+10", "moo cow", "png:-"}, c.Args)
+}
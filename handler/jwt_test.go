@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"context"
+	"crypto/x509"
+	"derivative-ms/api"
+	"derivative-ms/config"
+	"github.com/cristalhq/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+const hmacKey = "moo-secret"
+
+func newHS256Token(t *testing.T, key string) *jwt.Token {
+	return newHS256TokenWithClaims(t, key, jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	})
+}
+
+func newHS256TokenWithClaims(t *testing.T, key string, claims jwt.RegisteredClaims) *jwt.Token {
+	signer, err := jwt.NewSignerHS(jwt.HS256, []byte(key))
+	require.Nil(t, err)
+	token, err := jwt.NewBuilder(signer).Build(claims)
+	require.Nil(t, err)
+	return token
+}
+
+// envKeys answers the keys found in the environment
+func envKeys(t *testing.T) *KeySet {
+	ks := &KeySet{}
+	require.Nil(t, ks.Load())
+	return ks
+}
+
+func Test_JWTHandlerMissingToken(t *testing.T) {
+	ctx := newContext("moo-msg-id", config.HoudiniDestination, api.MessageBody{})
+
+	h := &JWTHandler{RejectIfTokenMissing: true, VerifyTokens: true}
+	_, err := h.Handle(ctx.ctx, nil, &api.MessageBody{})
+	assert.ErrorIs(t, err, api.TokenMissingErr, "expected a tokenless message to be rejected when tokens are required")
+
+	h = &JWTHandler{RejectIfTokenMissing: false, VerifyTokens: true}
+	_, err = h.Handle(ctx.ctx, nil, &api.MessageBody{})
+	assert.Nil(t, err, "expected a tokenless message to be accepted when tokens are not required")
+}
+
+func Test_JWTHandlerVerifyTokens(t *testing.T) {
+	t.Setenv(config.VarDrupalJwtPrivateKey, hmacKey)
+	ctx := newContext("moo-msg-id", config.HoudiniDestination, api.MessageBody{})
+	forged := newHS256Token(t, "not-"+hmacKey)
+
+	h := &JWTHandler{RejectIfTokenMissing: true, VerifyTokens: true, Keys: envKeys(t)}
+	_, err := h.Handle(ctx.ctx, newHS256Token(t, hmacKey), &api.MessageBody{})
+	assert.Nil(t, err)
+	_, err = h.Handle(ctx.ctx, forged, &api.MessageBody{})
+	assert.ErrorIs(t, err, api.TokenInvalidErr, "expected a token with an invalid signature to be rejected")
+
+	// the signature is not checked when verification is disabled
+	h = &JWTHandler{RejectIfTokenMissing: true, VerifyTokens: false}
+	handledCtx, err := h.Handle(ctx.ctx, forged, &api.MessageBody{})
+	assert.Nil(t, err)
+	assert.Equal(t, forged, handledCtx.Value(api.MsgJwt))
+}
+
+func Test_JWTLoggingHandlerMissingToken(t *testing.T) {
+	ctx := newContext("moo-msg-id", config.HoudiniDestination, api.MessageBody{})
+	_, err := (&JWTLoggingHandler{}).Handle(ctx.ctx, nil, &api.MessageBody{})
+	assert.Nil(t, err)
+}
+
+func Test_JWTHandlerClaims(t *testing.T) {
+	t.Setenv(config.VarDrupalJwtPrivateKey, hmacKey)
+	var (
+		ctx = newContext("moo-msg-id", config.HoudiniDestination, api.MessageBody{})
+		now = time.Now()
+		h   = &JWTHandler{
+			RejectIfTokenMissing: true,
+			VerifyTokens:         true,
+			Issuer:               "islandora",
+			Audience:             []string{"derivative-ms", "houdini"},
+			Leeway:               time.Minute,
+			Keys:                 envKeys(t),
+		}
+		valid = jwt.RegisteredClaims{
+			Issuer:    "islandora",
+			Audience:  jwt.Audience{"houdini"},
+			NotBefore: jwt.NewNumericDate(now.Add(-time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		}
+	)
+
+	for name, tc := range map[string]struct {
+		modify   func(c *jwt.RegisteredClaims)
+		expected error
+	}{
+		"Valid":                 {func(c *jwt.RegisteredClaims) {}, nil},
+		"Expired":               {func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-2 * time.Minute)) }, api.TokenExpiredErr},
+		"ExpiredWithinLeeway":   {func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-30 * time.Second)) }, nil},
+		"NotYetValid":           {func(c *jwt.RegisteredClaims) { c.NotBefore = jwt.NewNumericDate(now.Add(2 * time.Minute)) }, api.TokenNotYetValidErr},
+		"NotBeforeWithinLeeway": {func(c *jwt.RegisteredClaims) { c.NotBefore = jwt.NewNumericDate(now.Add(30 * time.Second)) }, nil},
+		"Issuer":                {func(c *jwt.RegisteredClaims) { c.Issuer = "moo" }, api.TokenIssuerErr},
+		"Audience":              {func(c *jwt.RegisteredClaims) { c.Audience = jwt.Audience{"moo"} }, api.TokenAudienceErr},
+	} {
+		t.Run(name, func(t *testing.T) {
+			claims := valid
+			tc.modify(&claims)
+			_, err := h.Handle(ctx.ctx, newHS256TokenWithClaims(t, hmacKey, claims), &api.MessageBody{})
+			if tc.expected == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.ErrorIs(t, err, tc.expected)
+			}
+		})
+	}
+}
+
+func Test_JWTHandlerConfigure(t *testing.T) {
+	t.Setenv(config.VarDrupalJwtPrivateKey, hmacKey)
+	c := config.Configuration{
+		Key: "jwt",
+		Config: &config.Config{
+			Json: map[string]interface{}{
+				"jwt": map[string]interface{}{
+					"requireTokens": true,
+					"verifyTokens":  false,
+					"issuer":        "islandora",
+					"audience":      []interface{}{"derivative-ms"},
+					"leeway":        "30s",
+				},
+			},
+		},
+	}
+
+	h := &JWTHandler{}
+	require.Nil(t, h.Configure(c))
+	assert.True(t, h.RejectIfTokenMissing)
+	assert.False(t, h.VerifyTokens)
+	assert.Equal(t, "islandora", h.Issuer)
+	assert.Equal(t, []string{"derivative-ms"}, h.Audience)
+	assert.Equal(t, 30*time.Second, h.Leeway)
+
+	// issuer, audience, and leeway are optional
+	c.Json["jwt"] = map[string]interface{}{"requireTokens": true, "verifyTokens": true}
+	h = &JWTHandler{}
+	require.Nil(t, h.Configure(c))
+	assert.Equal(t, "", h.Issuer)
+	assert.Empty(t, h.Audience)
+	assert.Equal(t, time.Duration(0), h.Leeway)
+}
+
+func Test_JWTHandlerConfigureNoKeys(t *testing.T) {
+	t.Setenv(config.VarDrupalJwtPublicKey, "")
+	t.Setenv(config.VarDrupalJwtPrivateKey, "")
+	c := config.Configuration{
+		Key: "jwt",
+		Config: &config.Config{
+			Json: map[string]interface{}{
+				"jwt": map[string]interface{}{"requireTokens": true, "verifyTokens": true},
+			},
+		},
+	}
+
+	assert.NotNil(t, (&JWTHandler{}).Configure(c), "expected an error when tokens must be verified, but there are no keys")
+}
+
+func Test_JWTHandlerServiceAccount(t *testing.T) {
+	t.Setenv(config.VarDrupalJwtPublicKey, "")
+	t.Setenv(config.VarDrupalJwtPrivateKey, hmacKey)
+	var (
+		ctx     = newContext("moo-msg-id", config.HoudiniDestination, api.MessageBody{})
+		now     = time.Now()
+		expired = jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(now.Add(-time.Hour))}
+	)
+
+	minter, err := newTokenMinter("", map[string]interface{}{"sub": "derivative-ms"}, 5*time.Minute)
+	require.Nil(t, err)
+	h := &JWTHandler{RejectIfTokenMissing: true, VerifyTokens: true, Keys: envKeys(t), Minter: minter}
+
+	assertMinted := func(t *testing.T, handledCtx context.Context) {
+		minted, ok := handledCtx.Value(api.MsgJwt).(*jwt.Token)
+		require.True(t, ok, "expected a service account token")
+		assert.Nil(t, h.Keys.Verify(context.Background(), minted))
+		claims := jwt.RegisteredClaims{}
+		require.Nil(t, minted.DecodeClaims(&claims))
+		assert.Equal(t, "derivative-ms", claims.Subject)
+		assert.True(t, claims.IsValidExpiresAt(now.Add(4*time.Minute)))
+		assert.False(t, claims.IsValidExpiresAt(now.Add(6*time.Minute)))
+	}
+
+	// an expired token is replaced
+	handledCtx, err := h.Handle(ctx.ctx, newHS256TokenWithClaims(t, hmacKey, expired), &api.MessageBody{})
+	require.Nil(t, err)
+	assertMinted(t, handledCtx)
+
+	// an absent token is replaced
+	handledCtx, err = h.Handle(ctx.ctx, nil, &api.MessageBody{})
+	require.Nil(t, err)
+	assertMinted(t, handledCtx)
+
+	// a forged token is not replaced, even if it is expired
+	_, err = h.Handle(ctx.ctx, newHS256TokenWithClaims(t, "not-"+hmacKey, expired), &api.MessageBody{})
+	assert.ErrorIs(t, err, api.TokenInvalidErr)
+}
+
+func Test_JWTHandlerConfigureServiceAccount(t *testing.T) {
+	t.Setenv(config.VarDrupalJwtPrivateKey, pemEncode("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(newRSAKey(t))))
+	c := config.Configuration{
+		Key: "jwt",
+		Config: &config.Config{
+			Json: map[string]interface{}{
+				"jwt": map[string]interface{}{
+					"requireTokens": true,
+					"verifyTokens":  true,
+					"serviceAccount": map[string]interface{}{
+						"claims": map[string]interface{}{"sub": "derivative-ms", "roles": []interface{}{"fedoraadmin"}},
+						"ttl":    "10m",
+					},
+				},
+			},
+		},
+	}
+
+	h := &JWTHandler{}
+	require.Nil(t, h.Configure(c))
+	require.NotNil(t, h.Minter)
+	assert.Equal(t, 10*time.Minute, h.Minter.Ttl)
+
+	// the public key is derived from the private key, so minted tokens can be verified
+	minted, err := h.Minter.Mint(time.Now())
+	require.Nil(t, err)
+	assert.Equal(t, jwt.RS256, minted.Header().Algorithm)
+	assert.Nil(t, h.Keys.Verify(context.Background(), minted))
+}
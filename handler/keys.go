@@ -0,0 +1,470 @@
+package handler
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"derivative-ms/api"
+	"derivative-ms/config"
+	"derivative-ms/env"
+	"derivative-ms/logging"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/cristalhq/jwt/v4"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultKeyRefreshInterval = time.Minute
+	// jwksTimeout bounds the time taken to read a JWKS from an http(s) URL, including reading the response body
+	jwksTimeout = 10 * time.Second
+)
+
+// jwksClient reads JWKS documents from http(s) URLs
+var jwksClient = &http.Client{Timeout: jwksTimeout}
+
+// KeySet holds the keys used to verify the signatures of JWTs.  Keys are read from the DRUPAL_JWT_PUBLIC_KEY and
+// DRUPAL_JWT_PRIVATE_KEY environment variables, PEM files, and JSON Web Key Sets.
+//
+// When a token refers to a key id that is not in the set, the keys are read again, so keys rotated by Drupal are picked
+// up without restarting the application.  The keys are read at most once per RefreshInterval, however many tokens
+// refer to unknown keys, and a JWKS read from an http(s) URL is abandoned after jwksTimeout.
+type KeySet struct {
+	// PemFiles are paths to files containing one or more PEM encoded keys or certificates
+	PemFiles []string
+	// Jwks are file paths or http(s) URLs of JSON Web Key Set documents
+	Jwks []string
+	// RefreshInterval is the minimum time between reads of the keys, defaultKeyRefreshInterval if zero
+	RefreshInterval time.Duration
+
+	mu     sync.RWMutex
+	keys   []verificationKey
+	loaded time.Time
+}
+
+// verificationKey is a key that may verify the signature of a JWT
+type verificationKey struct {
+	// id is matched against the 'kid' header of a token, empty if the key has no id
+	id string
+	// alg restricts the key to a single algorithm, empty if the key may be used with any algorithm suited to its type
+	alg jwt.Algorithm
+	// key is a *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey, or []byte (a shared HMAC secret)
+	key interface{}
+}
+
+// jsonWebKey is a member of a JSON Web Key Set (RFC 7517).  Only the members needed to verify signatures are present.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// Load reads every key of the KeySet, replacing any keys read previously.  An error is returned if any key cannot be
+// read.
+func (ks *KeySet) Load() error {
+	return ks.load(context.Background())
+}
+
+func (ks *KeySet) load(ctx context.Context) error {
+	keys, err := ks.read(ctx)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = keys
+	ks.loaded = time.Now()
+
+	return nil
+}
+
+// Len answers the number of keys in the KeySet
+func (ks *KeySet) Len() int {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return len(ks.keys)
+}
+
+// Verify verifies the signature of the token.  Keys with an id matching the 'kid' header of the token are tried first;
+// if there are none, keys without an id are tried.  If the signature cannot be verified by any key, the returned error
+// wraps api.TokenInvalidErr.  If the keys are read again because the token refers to an unknown key, they are read
+// using ctx, e.g. the context of the message carrying the token.
+func (ks *KeySet) Verify(ctx context.Context, token *jwt.Token) error {
+	var (
+		kid        = token.Header().KeyID
+		alg        = token.Header().Algorithm
+		candidates = ks.candidates(kid, alg)
+		err        error
+	)
+
+	if len(candidates) == 0 && ks.refresh(ctx) {
+		candidates = ks.candidates(kid, alg)
+	}
+
+	if len(candidates) == 0 {
+		return fmt.Errorf("handler: %w: no key with id '%s' for algorithm '%s'", api.TokenInvalidErr, kid, alg)
+	}
+
+	for _, k := range candidates {
+		var verifier jwt.Verifier
+		if verifier, err = newVerifier(alg, k.key); err != nil {
+			continue
+		}
+		if err = verifier.Verify(token); err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("handler: %w: %s", api.TokenInvalidErr, err)
+}
+
+// candidates answers the keys that may verify a token with the supplied key id and algorithm
+func (ks *KeySet) candidates(kid string, alg jwt.Algorithm) []verificationKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	var withId, withoutId []verificationKey
+	for _, k := range ks.keys {
+		if (k.alg != "" && k.alg != alg) || !suitable(alg, k.key) {
+			continue
+		}
+		switch {
+		case kid != "" && k.id == kid:
+			withId = append(withId, k)
+		case k.id == "":
+			withoutId = append(withoutId, k)
+		}
+	}
+
+	if len(withId) > 0 {
+		return withId
+	}
+
+	return withoutId
+}
+
+// refresh reads the keys again, unless they were read (or a read was begun) within the RefreshInterval.  Answers true if
+// the keys were read.
+func (ks *KeySet) refresh(ctx context.Context) bool {
+	interval := ks.RefreshInterval
+	if interval == 0 {
+		interval = defaultKeyRefreshInterval
+	}
+
+	ks.mu.Lock()
+	if time.Since(ks.loaded) < interval {
+		ks.mu.Unlock()
+		return false
+	}
+	// claim this refresh, so concurrent callers do not read the keys as well
+	ks.loaded = time.Now()
+	ks.mu.Unlock()
+
+	if err := ks.load(ctx); err != nil {
+		logging.FromContext(ctx).Warn("handler: unable to refresh JWT verification keys, continuing with the keys read previously", logging.FieldError, err)
+		return false
+	}
+
+	return true
+}
+
+func (ks *KeySet) read(ctx context.Context) ([]verificationKey, error) {
+	var keys []verificationKey
+
+	if publicKey := env.GetOrDefault(config.VarDrupalJwtPublicKey, ""); publicKey != "" {
+		k, err := parsePemKeys([]byte(publicKey))
+		if err != nil {
+			return nil, fmt.Errorf("handler: unable to read JWT key from %s: %w", config.VarDrupalJwtPublicKey, err)
+		}
+		keys = append(keys, k...)
+	}
+
+	if privateKey := env.GetOrDefault(config.VarDrupalJwtPrivateKey, ""); privateKey != "" {
+		if block, _ := pem.Decode([]byte(privateKey)); block != nil {
+			k, err := parsePemKeys([]byte(privateKey))
+			if err != nil {
+				return nil, fmt.Errorf("handler: unable to read JWT key from %s: %w", config.VarDrupalJwtPrivateKey, err)
+			}
+			keys = append(keys, k...)
+		} else {
+			// not PEM encoded, so a shared secret used by the HMAC algorithms
+			keys = append(keys, verificationKey{key: []byte(privateKey)})
+		}
+	}
+
+	for _, file := range ks.PemFiles {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("handler: unable to read JWT keys from '%s': %w", file, err)
+		}
+		k, err := parsePemKeys(b)
+		if err != nil {
+			return nil, fmt.Errorf("handler: unable to read JWT keys from '%s': %w", file, err)
+		}
+		keys = append(keys, k...)
+	}
+
+	for _, location := range ks.Jwks {
+		b, err := readLocation(ctx, location)
+		if err != nil {
+			return nil, fmt.Errorf("handler: unable to read JWKS from '%s': %w", location, err)
+		}
+		k, err := parseJwks(b)
+		if err != nil {
+			return nil, fmt.Errorf("handler: unable to read JWKS from '%s': %w", location, err)
+		}
+		keys = append(keys, k...)
+	}
+
+	return keys, nil
+}
+
+// readLocation answers the content of an http(s) URL or a file.  A URL is read by jwksClient, and the request is
+// cancelled if ctx is done.
+func readLocation(ctx context.Context, location string) ([]byte, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		return ioutil.ReadFile(location)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := jwksClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code '%d', message: '%s'", res.StatusCode, res.Status)
+	}
+
+	return ioutil.ReadAll(res.Body)
+}
+
+// parsePemKeys parses every PEM block in data as a public key, private key, or certificate, answering the public keys.
+// RSA keys may be PKCS #1 or PKIX encoded.
+func parsePemKeys(data []byte) ([]verificationKey, error) {
+	var keys []verificationKey
+
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		var (
+			key interface{}
+			err error
+		)
+
+		switch block.Type {
+		case "RSA PUBLIC KEY":
+			// PKIX encoded keys are commonly mislabelled as PKCS #1
+			if key, err = x509.ParsePKCS1PublicKey(block.Bytes); err != nil {
+				key, err = x509.ParsePKIXPublicKey(block.Bytes)
+			}
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = cert.PublicKey
+			}
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		default:
+			return nil, fmt.Errorf("unsupported PEM block type '%s'", block.Type)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid PEM encoded %s: %w", block.Type, err)
+		}
+
+		if key, err = publicKey(key); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, verificationKey{key: key})
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no PEM encoded keys found")
+	}
+
+	return keys, nil
+}
+
+// parseJwks parses a JSON Web Key Set, answering the keys that may be used to verify signatures.  Keys of an unknown
+// type are skipped.
+func parseJwks(data []byte) ([]verificationKey, error) {
+	var (
+		set struct {
+			Keys []jsonWebKey `json:"keys"`
+		}
+		keys []verificationKey
+	)
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key '%s': %w", jwk.Kid, err)
+		}
+		if key == nil {
+			logging.Default().Warn("handler: skipping JWK with an unsupported key type", "kid", jwk.Kid, "kty", jwk.Kty)
+			continue
+		}
+
+		keys = append(keys, verificationKey{id: jwk.Kid, alg: jwt.Algorithm(jwk.Alg), key: key})
+	}
+
+	return keys, nil
+}
+
+// publicKey answers the key represented by the JWK, or nil if the key type is not supported
+func (jwk jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent out of range")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve '%s'", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key size")
+		}
+		return ed25519.PublicKey(x), nil
+
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(jwk.K)
+	}
+
+	return nil, nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// publicKey answers the public portion of a key parsed by the x509 package
+func publicKey(key interface{}) (interface{}, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return k, nil
+	case *rsa.PrivateKey:
+		return &k.PublicKey, nil
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey, nil
+	case ed25519.PrivateKey:
+		return k.Public(), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
+
+// suitable answers true if the key may be used with the algorithm
+func suitable(alg jwt.Algorithm, key interface{}) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg.String(), "RS") || strings.HasPrefix(alg.String(), "PS")
+	case *ecdsa.PublicKey:
+		return strings.HasPrefix(alg.String(), "ES")
+	case ed25519.PublicKey:
+		return alg == jwt.EdDSA
+	case []byte:
+		return strings.HasPrefix(alg.String(), "HS")
+	}
+
+	return false
+}
+
+func newVerifier(alg jwt.Algorithm, key interface{}) (jwt.Verifier, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(alg.String(), "PS") {
+			return jwt.NewVerifierPS(alg, k)
+		}
+		return jwt.NewVerifierRS(alg, k)
+	case *ecdsa.PublicKey:
+		return jwt.NewVerifierES(alg, k)
+	case ed25519.PublicKey:
+		return jwt.NewVerifierEdDSA(k)
+	case []byte:
+		return jwt.NewVerifierHS(alg, k)
+	}
+
+	return nil, fmt.Errorf("handler: unknown or unsupported JWT algorithm '%s'", alg)
+}
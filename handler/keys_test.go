@@ -0,0 +1,250 @@
+package handler
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"derivative-ms/api"
+	"derivative-ms/config"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/cristalhq/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	return key
+}
+
+func newSignedToken(t *testing.T, signer jwt.Signer, kid string) *jwt.Token {
+	var opts []jwt.BuilderOption
+	if kid != "" {
+		opts = append(opts, jwt.WithKeyID(kid))
+	}
+	token, err := jwt.NewBuilder(signer, opts...).Build(jwt.RegisteredClaims{Subject: "moo"})
+	require.Nil(t, err)
+	return token
+}
+
+func newRSSigner(t *testing.T, key *rsa.PrivateKey) jwt.Signer {
+	signer, err := jwt.NewSignerRS(jwt.RS256, key)
+	require.Nil(t, err)
+	return signer
+}
+
+func pemEncode(blockType string, b []byte) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: b}))
+}
+
+func rsaJwk(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"alg": "RS256",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func jwksDocument(t *testing.T, keys ...map[string]string) []byte {
+	b, err := json.Marshal(map[string]interface{}{"keys": keys})
+	require.Nil(t, err)
+	return b
+}
+
+func Test_KeySetPemEncodings(t *testing.T) {
+	key := newRSAKey(t)
+	pkix, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.Nil(t, err)
+
+	for name, encoded := range map[string]string{
+		"PKCS1":              pemEncode("RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&key.PublicKey)),
+		"PKIX":               pemEncode("PUBLIC KEY", pkix),
+		"PKIXMislabelled":    pemEncode("RSA PUBLIC KEY", pkix),
+		"PKCS1PrivateKey":    pemEncode("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)),
+		"MultipleBlocks":     pemEncode("PUBLIC KEY", pkix) + pemEncode("RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&newRSAKey(t).PublicKey)),
+		"MultipleBlocksLast": pemEncode("RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&newRSAKey(t).PublicKey)) + pemEncode("PUBLIC KEY", pkix),
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(config.VarDrupalJwtPublicKey, encoded)
+			t.Setenv(config.VarDrupalJwtPrivateKey, "")
+			ks := &KeySet{}
+			require.Nil(t, ks.Load())
+			assert.Nil(t, ks.Verify(context.Background(), newSignedToken(t, newRSSigner(t, key), "")))
+		})
+	}
+}
+
+func Test_KeySetEnvPrivateKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	require.Nil(t, err)
+
+	t.Setenv(config.VarDrupalJwtPublicKey, "")
+	t.Setenv(config.VarDrupalJwtPrivateKey, pemEncode("EC PRIVATE KEY", der))
+
+	ks := &KeySet{}
+	require.Nil(t, ks.Load())
+	signer, err := jwt.NewSignerES(jwt.ES256, key)
+	require.Nil(t, err)
+	assert.Nil(t, ks.Verify(context.Background(), newSignedToken(t, signer, "")))
+}
+
+func Test_KeySetPemFiles(t *testing.T) {
+	t.Setenv(config.VarDrupalJwtPublicKey, "")
+	t.Setenv(config.VarDrupalJwtPrivateKey, "")
+	key := newRSAKey(t)
+	file := filepath.Join(t.TempDir(), "public.pem")
+	require.Nil(t, ioutil.WriteFile(file, []byte(pemEncode("RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&key.PublicKey))), 0600))
+
+	ks := &KeySet{PemFiles: []string{file}}
+	require.Nil(t, ks.Load())
+	assert.Nil(t, ks.Verify(context.Background(), newSignedToken(t, newRSSigner(t, key), "")))
+	assert.ErrorIs(t, ks.Verify(context.Background(), newSignedToken(t, newRSSigner(t, newRSAKey(t)), "")), api.TokenInvalidErr)
+
+	assert.NotNil(t, (&KeySet{PemFiles: []string{filepath.Join(t.TempDir(), "moo.pem")}}).Load())
+}
+
+func Test_KeySetJwksKid(t *testing.T) {
+	t.Setenv(config.VarDrupalJwtPublicKey, "")
+	t.Setenv(config.VarDrupalJwtPrivateKey, "")
+	key1, key2 := newRSAKey(t), newRSAKey(t)
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	require.Nil(t, ioutil.WriteFile(file, jwksDocument(t, rsaJwk("key-1", &key1.PublicKey), rsaJwk("key-2", &key2.PublicKey)), 0600))
+
+	ks := &KeySet{Jwks: []string{file}}
+	require.Nil(t, ks.Load())
+	assert.Equal(t, 2, ks.Len())
+
+	assert.Nil(t, ks.Verify(context.Background(), newSignedToken(t, newRSSigner(t, key1), "key-1")))
+	assert.Nil(t, ks.Verify(context.Background(), newSignedToken(t, newRSSigner(t, key2), "key-2")))
+
+	// the key is selected by the kid header, a token signed by another key is rejected
+	assert.ErrorIs(t, ks.Verify(context.Background(), newSignedToken(t, newRSSigner(t, key1), "key-2")), api.TokenInvalidErr)
+	// keys with an id are not used for tokens with an unknown kid
+	assert.ErrorIs(t, ks.Verify(context.Background(), newSignedToken(t, newRSSigner(t, key1), "key-3")), api.TokenInvalidErr)
+}
+
+func Test_KeySetJwksRotation(t *testing.T) {
+	t.Setenv(config.VarDrupalJwtPublicKey, "")
+	t.Setenv(config.VarDrupalJwtPrivateKey, "")
+	var (
+		key1, key2 = newRSAKey(t), newRSAKey(t)
+		mu         sync.Mutex
+		document   = jwksDocument(t, rsaJwk("key-1", &key1.PublicKey))
+		requests   = 0
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		fmt.Fprintf(w, "%s", document)
+	}))
+	defer server.Close()
+
+	ks := &KeySet{Jwks: []string{server.URL}, RefreshInterval: time.Nanosecond}
+	require.Nil(t, ks.Load())
+	assert.Nil(t, ks.Verify(context.Background(), newSignedToken(t, newRSSigner(t, key1), "key-1")))
+	assert.Equal(t, 1, requests)
+
+	// Drupal rotates its key: the JWKS is read again when a token refers to an unknown key
+	mu.Lock()
+	document = jwksDocument(t, rsaJwk("key-2", &key2.PublicKey))
+	mu.Unlock()
+
+	assert.Nil(t, ks.Verify(context.Background(), newSignedToken(t, newRSSigner(t, key2), "key-2")))
+	assert.Equal(t, 2, requests)
+
+	// the JWKS is not read again within the refresh interval
+	ks.RefreshInterval = time.Hour
+	assert.ErrorIs(t, ks.Verify(context.Background(), newSignedToken(t, newRSSigner(t, key1), "key-3")), api.TokenInvalidErr)
+	assert.Equal(t, 2, requests)
+}
+
+func Test_KeySetJwksRefreshBounded(t *testing.T) {
+	t.Setenv(config.VarDrupalJwtPublicKey, "")
+	t.Setenv(config.VarDrupalJwtPrivateKey, "")
+	var (
+		key      = newRSAKey(t)
+		document = jwksDocument(t, rsaJwk("key-1", &key.PublicKey))
+		mu       sync.Mutex
+		requests = 0
+		hang     = make(chan struct{})
+	)
+	defer close(hang)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		first := requests == 1
+		mu.Unlock()
+		if !first {
+			// a hung endpoint, abandoned when the request is cancelled
+			select {
+			case <-hang:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		fmt.Fprintf(w, "%s", document)
+	}))
+	defer server.Close()
+
+	ks := &KeySet{Jwks: []string{server.URL}, RefreshInterval: time.Nanosecond}
+	require.Nil(t, ks.Load())
+
+	// the refresh is abandoned once the context of the message is done
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.ErrorIs(t, ks.Verify(ctx, newSignedToken(t, newRSSigner(t, key), "key-2")), api.TokenInvalidErr)
+	assert.True(t, time.Since(start) < 5*time.Second)
+
+	// a flood of tokens referring to unknown keys reads the JWKS at most once per refresh interval
+	ks.RefreshInterval = time.Hour
+	ks.mu.Lock()
+	ks.loaded = time.Time{}
+	ks.mu.Unlock()
+	tokens := make([]*jwt.Token, 20)
+	for i := range tokens {
+		tokens[i] = newSignedToken(t, newRSSigner(t, key), fmt.Sprintf("unknown-%d", i))
+	}
+	mu.Lock()
+	before := requests
+	mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, token := range tokens {
+		wg.Add(1)
+		go func(token *jwt.Token) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			assert.ErrorIs(t, ks.Verify(ctx, token), api.TokenInvalidErr)
+		}(token)
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.LessOrEqual(t, requests-before, 1)
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"context"
+	"derivative-ms/api"
+	"derivative-ms/jobs"
+	"derivative-ms/logging"
+	"derivative-ms/metrics"
+	"github.com/cristalhq/jwt/v4"
+	"time"
+)
+
+var (
+	handlerDuration = metrics.NewHistogram("derivative_handler_duration_seconds",
+		"Time taken by each handler to handle a message", metrics.DurationBuckets, "handler")
+	handlerErrors = metrics.NewCounter("derivative_handler_errors_total",
+		"Messages each handler failed to handle", "handler")
+	commandExits = metrics.NewCounter("derivative_command_exits_total",
+		"Exits of the commands run by handlers by exit code, or -1 if the command was killed by a signal", "command", "code")
+)
+
+// MeteredHandler records the time taken by Handler to handle each message, and whether it failed, in metrics labeled
+// by Key, and in the job carried by its context.  The records logged by Handler using the logger carried by its context
+// are attributed to Key.
+type MeteredHandler struct {
+	Handler api.Handler
+	// Key identifies the configuration of Handler
+	Key string
+}
+
+func (h *MeteredHandler) Handle(ctx context.Context, t *jwt.Token, b *api.MessageBody) (context.Context, error) {
+	var (
+		logger  = logging.FromContext(ctx)
+		handler = logger.With(logging.FieldHandler, h.Key)
+		start   = time.Now()
+		claimed = len(api.HandledBy(ctx))
+		run     = jobs.Handler{Key: h.Key}
+	)
+
+	handledCtx, err := h.Handler.Handle(logging.WithLogger(ctx, handler), t, b)
+	elapsed := time.Since(start)
+	handlerDuration.Observe(elapsed.Seconds(), h.Key)
+	if err != nil {
+		handlerErrors.Inc(h.Key)
+		handler.Debug("handler: failed", logging.FieldDurationMs, elapsed.Milliseconds(), logging.FieldError, err)
+		run.Error = err.Error()
+	} else {
+		handler.Debug("handler: completed", logging.FieldDurationMs, elapsed.Milliseconds())
+	}
+
+	run.Elapsed = elapsed
+	run.Claimed = handledCtx != nil && len(api.HandledBy(handledCtx)) > claimed
+	jobs.FromContext(ctx).Handler(run)
+
+	// records logged by the handlers that follow are not attributed to this handler
+	if handledCtx != nil {
+		handledCtx = logging.WithLogger(handledCtx, logger)
+	}
+
+	return handledCtx, err
+}
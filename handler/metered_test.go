@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"context"
+	"derivative-ms/api"
+	"errors"
+	"github.com/cristalhq/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os/exec"
+	"testing"
+)
+
+// handlerFunc adapts a function to the api.Handler interface
+type handlerFunc func(ctx context.Context, t *jwt.Token, b *api.MessageBody) (context.Context, error)
+
+func (f handlerFunc) Handle(ctx context.Context, t *jwt.Token, b *api.MessageBody) (context.Context, error) {
+	return f(ctx, t, b)
+}
+
+func Test_MeteredHandler(t *testing.T) {
+	var (
+		moo  = errors.New("moo")
+		fail bool
+		h    = &MeteredHandler{Key: "metered", Handler: handlerFunc(func(ctx context.Context, t *jwt.Token, b *api.MessageBody) (context.Context, error) {
+			if fail {
+				return ctx, moo
+			}
+			return api.Handled(ctx, "metered"), nil
+		})}
+	)
+
+	ctx, err := h.Handle(context.Background(), nil, &api.MessageBody{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"metered"}, api.HandledBy(ctx))
+	assert.Equal(t, uint64(1), handlerDuration.Count("metered"))
+	assert.Equal(t, float64(0), handlerErrors.Value("metered"))
+
+	fail = true
+	_, err = h.Handle(context.Background(), nil, &api.MessageBody{})
+	assert.ErrorIs(t, err, moo)
+	assert.Equal(t, uint64(2), handlerDuration.Count("metered"))
+	assert.Equal(t, float64(1), handlerErrors.Value("metered"))
+}
+
+func Test_StartRecordsExitCode(t *testing.T) {
+	shPath, err := exec.LookPath("sh")
+	require.Nil(t, err)
+
+	exits := commandExits.Value("sh", "3")
+
+	c := exec.Command(shPath, "-c", "exit 3")
+	stop, err := start(context.Background(), c)
+	require.Nil(t, err)
+	assert.NotNil(t, c.Wait())
+	stop()
+
+	assert.Equal(t, exits+1, commandExits.Value("sh", "3"))
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"derivative-ms/config"
+	"derivative-ms/env"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/cristalhq/jwt/v4"
+	"time"
+)
+
+const defaultMintTtl = 15 * time.Minute
+
+// TokenMinter signs short-lived service account tokens, which are used in place of message tokens that have expired,
+// or are absent.  Tokens are signed with the key in the DRUPAL_JWT_PRIVATE_KEY environment variable.
+type TokenMinter struct {
+	// Claims are included in every token, e.g. the 'sub' or 'uid' of the service account
+	Claims map[string]interface{}
+	// Ttl is the lifetime of each token
+	Ttl time.Duration
+
+	signer jwt.Signer
+}
+
+// newTokenMinter answers a TokenMinter signing tokens with the key in DRUPAL_JWT_PRIVATE_KEY.  A PEM encoded RSA,
+// ECDSA, or Ed25519 private key is used with an asymmetric algorithm (RS256, ES256/ES384/ES512, or EdDSA unless alg
+// is supplied); any other value is used as an HS256 shared secret.
+func newTokenMinter(alg jwt.Algorithm, claims map[string]interface{}, ttl time.Duration) (*TokenMinter, error) {
+	privateKey := env.GetOrDefault(config.VarDrupalJwtPrivateKey, "")
+	if privateKey == "" {
+		return nil, fmt.Errorf("handler: unable to mint JWTs, %s is empty", config.VarDrupalJwtPrivateKey)
+	}
+
+	signer, err := newSigner(alg, []byte(privateKey))
+	if err != nil {
+		return nil, fmt.Errorf("handler: unable to mint JWTs with the key in %s: %w", config.VarDrupalJwtPrivateKey, err)
+	}
+
+	if ttl == 0 {
+		ttl = defaultMintTtl
+	}
+
+	return &TokenMinter{Claims: claims, Ttl: ttl, signer: signer}, nil
+}
+
+// configureMinter answers a TokenMinter configured by the 'serviceAccount' parameter of the JWTHandler, which may
+// contain the 'claims' of the service account tokens, their 'ttl', and the signing 'algorithm'
+func configureMinter(serviceAccount *map[string]interface{}) (*TokenMinter, error) {
+	var (
+		claims map[string]interface{}
+		ttl    time.Duration
+		alg    string
+		err    error
+	)
+
+	if claims, err = config.MapValue(serviceAccount, "claims"); err != nil && !errors.Is(err, config.NotFoundErr) {
+		return nil, err
+	}
+
+	if ttl, err = config.DurationValue(serviceAccount, "ttl"); err != nil && !errors.Is(err, config.NotFoundErr) {
+		return nil, err
+	}
+
+	if alg, err = config.StringValue(serviceAccount, "algorithm"); err != nil && !errors.Is(err, config.NotFoundErr) {
+		return nil, err
+	}
+
+	return newTokenMinter(jwt.Algorithm(alg), claims, ttl)
+}
+
+// Mint answers a token carrying the configured claims, issued at now and expiring Ttl later
+func (m *TokenMinter) Mint(now time.Time) (*jwt.Token, error) {
+	claims := make(map[string]interface{}, len(m.Claims)+2)
+	for k, v := range m.Claims {
+		claims[k] = v
+	}
+	claims["iat"] = jwt.NewNumericDate(now)
+	claims["exp"] = jwt.NewNumericDate(now.Add(m.Ttl))
+
+	t, err := jwt.NewBuilder(m.signer).Build(claims)
+	if err != nil {
+		return nil, fmt.Errorf("handler: unable to mint JWT: %w", err)
+	}
+
+	return t, nil
+}
+
+func newSigner(alg jwt.Algorithm, privateKey []byte) (jwt.Signer, error) {
+	block, _ := pem.Decode(privateKey)
+	if block == nil {
+		// not PEM encoded, so a shared secret
+		if alg == "" {
+			alg = jwt.HS256
+		}
+		return jwt.NewSignerHS(alg, privateKey)
+	}
+
+	var (
+		key interface{}
+		err error
+	)
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type '%s'", block.Type)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid PEM encoded %s: %w", block.Type, err)
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if alg == "" {
+			alg = jwt.RS256
+		}
+		if alg == jwt.PS256 || alg == jwt.PS384 || alg == jwt.PS512 {
+			return jwt.NewSignerPS(alg, k)
+		}
+		return jwt.NewSignerRS(alg, k)
+	case *ecdsa.PrivateKey:
+		if alg == "" {
+			switch k.Curve.Params().BitSize {
+			case 384:
+				alg = jwt.ES384
+			case 521:
+				alg = jwt.ES512
+			default:
+				alg = jwt.ES256
+			}
+		}
+		return jwt.NewSignerES(alg, k)
+	case ed25519.PrivateKey:
+		return jwt.NewSignerEdDSA(k)
+	}
+
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
@@ -0,0 +1,217 @@
+package handler
+
+import (
+	"context"
+	"derivative-ms/jobs"
+	"derivative-ms/logging"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxStderr is the maximum number of bytes of the end of a command's stderr kept by a stderrTail
+	maxStderr = 4096
+	// redacted replaces credentials in the command lines and stderr recorded in a job
+	redacted = "[REDACTED]"
+)
+
+var (
+	// authorizationValue matches the credentials of an Authorization header supplied as an argument, e.g. to ffmpeg,
+	// retaining the scheme
+	authorizationValue = regexp.MustCompile(`(?i)(authorization:\s*)(\w+\s+)?\S+`)
+	// jwtValue matches a JWT, which may be supplied as an argument by itself
+	jwtValue = regexp.MustCompile(`eyJ[\w-]*\.[\w-]+\.[\w-]*`)
+)
+
+// CommandError is returned when a command exits unsuccessfully, and carries the end of its stderr
+type CommandError struct {
+	// Command is the name of the executable, e.g. "convert"
+	Command string
+	// Stderr is the last maxStderr bytes written to stderr by the command, with credentials redacted
+	Stderr string
+	Err    error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("handler: command '%s' failed: %s, stderr: '%s'", e.Command, e.Err, e.Stderr)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// start starts c in its own process group, which is killed if ctx is done (e.g. the handler timed out) before the
+// returned stop function is invoked.  Handlers invoke stop once the process has exited, typically by deferring it.  If
+// the process has been waited for, stop records its exit code in commandExits.
+//
+// Unless the caller has already supplied one, the stderr of c is captured by a stderrTail, which is read concurrently
+// by exec.Cmd, so a command writing a lot to stderr cannot block.  The tail is attached to the error answered by wait,
+// and logged at the debug level by stop.  stop also records the command, and its stderr, in the job carried by ctx.
+func start(ctx context.Context, c *exec.Cmd) (stop func(), err error) {
+	if c.Stderr == nil {
+		c.Stderr = &stderrTail{}
+	}
+
+	setProcessGroup(c)
+	started := time.Now()
+	if err = c.Start(); err != nil {
+		return func() {}, err
+	}
+
+	kill := killOnCancel(ctx, c)
+	return func() {
+		kill()
+
+		run := jobs.Command{Line: commandLine(c), ExitCode: -1, Elapsed: time.Since(started)}
+		if c.ProcessState != nil {
+			run.ExitCode = c.ProcessState.ExitCode()
+			commandExits.Inc(filepath.Base(c.Path), strconv.Itoa(run.ExitCode))
+		}
+		if tail, ok := c.Stderr.(*stderrTail); ok && tail.Len() > 0 {
+			run.Stderr = redact(tail.String())
+			logging.FromContext(ctx).Debug("handler: command wrote to stderr", "command", filepath.Base(c.Path), "stderr", run.Stderr)
+		}
+		jobs.FromContext(ctx).Command(run)
+	}, nil
+}
+
+// commandLine answers the command line of c, with any credentials in its arguments redacted
+func commandLine(c *exec.Cmd) string {
+	args := []string{c.Path}
+	if len(c.Args) > 1 {
+		args = append(args, c.Args[1:]...)
+	}
+
+	for i := range args {
+		args[i] = redact(args[i])
+	}
+
+	return strings.Join(args, " ")
+}
+
+// redact answers s with the values of Authorization headers, and JWTs, replaced
+func redact(s string) string {
+	s = authorizationValue.ReplaceAllString(s, "${1}${2}"+redacted)
+	return jwtValue.ReplaceAllString(s, redacted)
+}
+
+// wait waits for the process started by c to exit.  If it exits unsuccessfully, and its stderr was captured by start,
+// the error is a *CommandError carrying the end of its stderr, with credentials redacted.
+func wait(c *exec.Cmd) error {
+	err := c.Wait()
+	if err == nil {
+		return nil
+	}
+
+	if tail, ok := c.Stderr.(*stderrTail); ok {
+		return &CommandError{Command: filepath.Base(c.Path), Stderr: redact(tail.String()), Err: err}
+	}
+
+	return err
+}
+
+// stderrTail is an io.Writer which keeps the last maxStderr bytes written to it, so the stderr of a command may be
+// captured without holding all of it in memory, or writing it to a file.  It is safe for concurrent use.
+type stderrTail struct {
+	mu        sync.Mutex
+	buf       []byte
+	truncated bool
+}
+
+func (t *stderrTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = append(t.buf, p...)
+	// compact only once the buffer has doubled, rather than on every write
+	if len(t.buf) > 2*maxStderr {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-maxStderr:]...)
+		t.truncated = true
+	}
+
+	return len(p), nil
+}
+
+// Len answers the number of bytes written, up to maxStderr
+func (t *stderrTail) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.buf) > maxStderr {
+		return maxStderr
+	}
+	return len(t.buf)
+}
+
+// String answers the last maxStderr bytes written, trimmed of surrounding whitespace.  If earlier bytes were discarded
+// the answer begins with an ellipsis, and any partial UTF-8 sequence is discarded too.
+func (t *stderrTail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b, truncated := t.buf, t.truncated
+	if len(b) > maxStderr {
+		b, truncated = b[len(b)-maxStderr:], true
+	}
+
+	if !truncated {
+		return strings.TrimSpace(string(b))
+	}
+
+	for len(b) > 0 && !utf8.RuneStart(b[0]) {
+		b = b[1:]
+	}
+	return "..." + strings.TrimSpace(string(b))
+}
+
+// killOnCancel kills the process started by c, and its process group if it has one, if ctx is cancelled before the
+// returned stop function is invoked.  Handlers invoke stop once the process has exited, typically by deferring it
+// immediately after starting the process.
+func killOnCancel(ctx context.Context, c *exec.Cmd) (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			if c.Process != nil {
+				killProcessGroup(c)
+			}
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+// cancelableReader answers the error of its context instead of io.EOF once the context is cancelled.  Used to wrap the
+// stdout of a process that is streamed to Drupal, so a process killed by killOnCancel aborts the upload rather than
+// completing it with a truncated body.
+type cancelableReader struct {
+	ctx context.Context
+	io.ReadCloser
+}
+
+func (r cancelableReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := r.ReadCloser.Read(p)
+	if err != nil {
+		if ctxErr := r.ctx.Err(); ctxErr != nil {
+			return n, ctxErr
+		}
+	}
+
+	return n, err
+}
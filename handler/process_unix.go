@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package handler
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup arranges for c to be started in a new process group, so its children may be killed with it
+func setProcessGroup(c *exec.Cmd) {
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.SysProcAttr.Setpgid = true
+}
+
+// killProcessGroup kills the process started by c, and any children in its process group
+func killProcessGroup(c *exec.Cmd) error {
+	if c.SysProcAttr != nil && c.SysProcAttr.Setpgid {
+		return syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+	}
+
+	return c.Process.Kill()
+}
@@ -0,0 +1,17 @@
+//go:build windows
+// +build windows
+
+package handler
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup is a no-op, process groups are not supported
+func setProcessGroup(c *exec.Cmd) {
+}
+
+// killProcessGroup kills the process started by c, its children are not killed
+func killProcessGroup(c *exec.Cmd) error {
+	return c.Process.Kill()
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"derivative-ms/api"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Registration describes a type of handler, identified by the 'handler-type' of its configuration.  Packages
+// providing handlers Register them when they are initialized, so a handler type is available to the application by
+// importing its package.
+type Registration struct {
+	// Type is the value of 'handler-type' that selects this handler
+	Type string
+	// Description summarizes what the handler does
+	Description string
+	// Parameters documents the configuration accepted by the handler, in addition to the parameters accepted by all
+	// handlers ('handler-type', 'order', and 'timeout')
+	Parameters []Parameter
+	// New answers a new, unconfigured, instance of the handler.  If the instance implements config.Configurable, it is
+	// configured before it handles messages.
+	New func() api.Handler
+}
+
+// Parameter documents a configuration parameter of a handler
+type Parameter struct {
+	Name        string
+	Required    bool
+	Description string
+}
+
+var registry = struct {
+	sync.RWMutex
+	registrations map[string]Registration
+}{registrations: make(map[string]Registration)}
+
+// Register makes a handler type available to the application.  Register panics if the type is empty, has no New
+// function, or is already registered, because it is expected to be called when a package is initialized.
+func Register(r Registration) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if r.Type == "" || r.New == nil {
+		panic(fmt.Sprintf("handler: registration of handler type '%s' requires a type and a New function", r.Type))
+	}
+
+	if _, exists := registry.registrations[r.Type]; exists {
+		panic(fmt.Sprintf("handler: handler type '%s' is already registered", r.Type))
+	}
+
+	registry.registrations[r.Type] = r
+}
+
+// Lookup answers the Registration of a handler type, and false if the type is not registered
+func Lookup(handlerType string) (Registration, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	r, ok := registry.registrations[handlerType]
+	return r, ok
+}
+
+// Registered answers the Registration of every handler type, sorted by type
+func Registered() []Registration {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	var result []Registration
+	for _, r := range registry.registrations {
+		result = append(result, r)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Type < result[j].Type
+	})
+
+	return result
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"context"
+	"derivative-ms/api"
+	"github.com/cristalhq/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"testing"
+)
+
+type mooHandler struct{}
+
+func (mooHandler) Handle(ctx context.Context, t *jwt.Token, b *api.MessageBody) (context.Context, error) {
+	return api.Handled(ctx, "moo"), nil
+}
+
+func Test_RegistryBuiltins(t *testing.T) {
+	for _, handlerType := range []string{"JWTLoggingHandler", "JWTHandler", "ImageMagickHandler", "FFMpegHandler",
+		"TesseractHandler", "Pdf2TextHandler", "FITSHandler"} {
+		r, ok := Lookup(handlerType)
+		require.True(t, ok, "expected '%s' to be registered", handlerType)
+		assert.NotNil(t, r.New())
+		assert.NotEmpty(t, r.Description)
+	}
+
+	r, _ := Lookup("ImageMagickHandler")
+	assert.IsType(t, &ImageMagickHandler{}, r.New())
+	assert.Equal(t, "commandPath", r.Parameters[0].Name)
+	assert.True(t, r.Parameters[0].Required)
+
+	_, ok := Lookup("MooHandler")
+	assert.False(t, ok)
+}
+
+func Test_RegistryRegister(t *testing.T) {
+	Register(Registration{Type: "Test_RegistryRegister", New: func() api.Handler { return mooHandler{} }})
+
+	r, ok := Lookup("Test_RegistryRegister")
+	require.True(t, ok)
+	assert.Equal(t, mooHandler{}, r.New())
+
+	var types []string
+	for _, r := range Registered() {
+		types = append(types, r.Type)
+	}
+	assert.Contains(t, types, "Test_RegistryRegister")
+	assert.True(t, sort.StringsAreSorted(types))
+
+	assert.Panics(t, func() {
+		Register(Registration{Type: "Test_RegistryRegister", New: func() api.Handler { return mooHandler{} }})
+	}, "expected a duplicate registration to panic")
+	assert.Panics(t, func() { Register(Registration{Type: "Test_RegistryNoNew"}) })
+}
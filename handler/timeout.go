@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"context"
+	"derivative-ms/api"
+	"errors"
+	"fmt"
+	"github.com/cristalhq/jwt/v4"
+	"time"
+)
+
+// TimeoutHandler bounds the time spent by Handler handling a message.  Handler is given a context which is cancelled
+// once Timeout expires, which kills any child process it started, and aborts its requests to Drupal.  If Handler fails
+// because the Timeout expired, the error answered by TimeoutHandler wraps api.TimeoutErr.
+type TimeoutHandler struct {
+	Handler api.Handler
+	// Key identifies the configuration of Handler
+	Key     string
+	Timeout time.Duration
+}
+
+func (h *TimeoutHandler) Handle(ctx context.Context, t *jwt.Token, b *api.MessageBody) (context.Context, error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, h.Timeout)
+	defer cancel()
+
+	handledCtx, err := h.Handler.Handle(timeoutCtx, t, b)
+	if handledCtx == nil {
+		handledCtx = ctx
+	}
+	if err != nil && errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		err = fmt.Errorf("handler: '%s' %w after %s: %s", h.Key, api.TimeoutErr, h.Timeout, err)
+	}
+
+	// the values set by Handler are retained, but the context answered is not cancelled when the timeout expires,
+	// so later handlers are unaffected by it
+	return valuesContext{Context: ctx, values: handledCtx}, err
+}
+
+// valuesContext answers the values of another context, but is otherwise its embedded Context
+type valuesContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c valuesContext) Value(key interface{}) interface{} {
+	return c.values.Value(key)
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"context"
+	"derivative-ms/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func Test_TimeoutHandler(t *testing.T) {
+	shPath, err := exec.LookPath("sh")
+	require.Nil(t, err)
+
+	suite, _ := newImageMagickSuite()
+	require.Nil(t, suite.handler.configure(suite.configuration, true))
+	suite.handler.CommandBuilder = &mockCmd{cmd: &exec.Cmd{Path: shPath, Args: []string{shPath, "-c", "sleep 60"}}}
+
+	h := &TimeoutHandler{Handler: suite.handler, Key: suite.configuration.Key, Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	handledCtx, err := h.Handle(suite.ctx.ctx, nil, &api.MessageBody{})
+	assert.ErrorIs(t, err, api.TimeoutErr)
+	assert.True(t, time.Since(start) < 30*time.Second, "expected the command to be killed")
+
+	// the context answered to later handlers is not cancelled by the timeout
+	assert.Nil(t, handledCtx.Err())
+	assert.Equal(t, suite.ctx.ctx.Value(api.MsgId), handledCtx.Value(api.MsgId))
+}
+
+func Test_TimeoutHandlerCompleted(t *testing.T) {
+	suite, _ := newImageMagickSuite()
+	require.Nil(t, suite.handler.configure(suite.configuration, true))
+
+	h := &TimeoutHandler{Handler: suite.handler, Key: suite.configuration.Key, Timeout: time.Minute}
+	echoPath, err := exec.LookPath("echo")
+	require.Nil(t, err)
+	suite.handler.CommandBuilder = &mockCmd{cmd: &exec.Cmd{Path: echoPath, Args: []string{echoPath, "moo"}}}
+
+	handledCtx, err := h.Handle(suite.ctx.ctx, nil, &api.MessageBody{})
+	require.Nil(t, err)
+	assert.Equal(t, []string{suite.configuration.Key}, api.HandledBy(handledCtx), "expected the claim of the handler to be retained")
+	assert.Nil(t, handledCtx.Err())
+
+	// a parent context that is cancelled is not reported as a timeout
+	ctx, cancel := context.WithCancel(suite.ctx.ctx)
+	cancel()
+	suite.handler.CommandBuilder = &mockCmd{cmd: &exec.Cmd{Path: echoPath, Args: []string{echoPath, "moo"}}}
+	_, err = h.Handle(ctx, nil, &api.MessageBody{})
+	assert.NotErrorIs(t, err, api.TimeoutErr)
+}
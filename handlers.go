@@ -0,0 +1,33 @@
+package main
+
+import (
+	"derivative-ms/handler"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// handlersMain implements the 'handlers' subcommand, which lists the registered handler types and their configuration
+// parameters.
+func handlersMain(args []string) {
+	flags := flag.NewFlagSet(fmt.Sprintf("%s %s", os.Args[0], cmdHandlers), flag.ExitOnError)
+	flags.Parse(args)
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer out.Flush()
+
+	for i, r := range handler.Registered() {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintf(out, "%s\t%s\n", r.Type, r.Description)
+		for _, p := range r.Parameters {
+			required := ""
+			if p.Required {
+				required = "required"
+			}
+			fmt.Fprintf(out, "  %s\t%s\t%s\n", p.Name, required, p.Description)
+		}
+	}
+}
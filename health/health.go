@@ -0,0 +1,154 @@
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Check is a condition that must hold for the application to be ready
+type Check struct {
+	Name string
+	// Fn answers an error describing why the condition does not hold, or nil
+	Fn func() error
+}
+
+// Executable answers a Check that path exists and is executable, e.g. the commandPath of a handler
+func Executable(path string) Check {
+	return Check{
+		Name: fmt.Sprintf("executable %s", path),
+		Fn: func() error {
+			_, err := exec.LookPath(path)
+			return err
+		},
+	}
+}
+
+// Monitor tracks the state of the subscription to the broker and the progress made handling messages, answering
+// whether the application is ready to handle messages and whether it is live.  The zero value is usable, and a nil
+// *Monitor records nothing, so listeners may be used without one.
+//
+// The application is ready if it has an active subscription and every Check succeeds.  It is live unless messages
+// have been in flight for longer than StallTimeout without any message being received or completed.
+type Monitor struct {
+	// StallTimeout is the maximum amount of time without progress while messages are in flight; if zero, the
+	// application is always live
+	StallTimeout time.Duration
+	// Checks must all succeed for the application to be ready
+	Checks []Check
+
+	mu         sync.Mutex
+	subscribed bool
+	inFlight   int
+	// progress is the last time a message was received or completed
+	progress time.Time
+	// now answers the current time, replaced by tests
+	now func() time.Time
+}
+
+// Subscribed records whether the listener has an active subscription
+func (m *Monitor) Subscribed(subscribed bool) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subscribed = subscribed
+}
+
+// Received records that a message has been received and is in flight
+func (m *Monitor) Received() {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight++
+	m.progress = m.time()
+}
+
+// Completed records that an in-flight message has been acked or nacked
+func (m *Monitor) Completed() {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight--
+	m.progress = m.time()
+}
+
+// Ready answers an error describing why the application is not ready to handle messages, or nil
+func (m *Monitor) Ready() error {
+	m.mu.Lock()
+	subscribed := m.subscribed
+	m.mu.Unlock()
+
+	var failed []string
+	if !subscribed {
+		failed = append(failed, "no active subscription")
+	}
+
+	for _, c := range m.Checks {
+		if err := c.Fn(); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", c.Name, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("health: not ready: %s", strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+// Live answers an error if messages are in flight, but no progress has been made for StallTimeout
+func (m *Monitor) Live() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.StallTimeout <= 0 || m.inFlight == 0 {
+		return nil
+	}
+
+	if stalled := m.time().Sub(m.progress); stalled > m.StallTimeout {
+		return fmt.Errorf("health: not live: %d messages in flight, no progress for %s", m.inFlight, stalled.Round(time.Second))
+	}
+
+	return nil
+}
+
+// ReadyHandler answers an http.Handler which responds 200 if the application is Ready, otherwise 503
+func (m *Monitor) ReadyHandler() http.Handler {
+	return statusHandler(m.Ready)
+}
+
+// LiveHandler answers an http.Handler which responds 200 if the application is Live, otherwise 503
+func (m *Monitor) LiveHandler() http.Handler {
+	return statusHandler(m.Live)
+}
+
+func statusHandler(status func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := status(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, err)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+func (m *Monitor) time() time.Time {
+	if m.now != nil {
+		return m.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,102 @@
+package health
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_Ready(t *testing.T) {
+	m := &Monitor{}
+	assert.NotNil(t, m.Ready(), "expected the monitor to be unready without a subscription")
+
+	m.Subscribed(true)
+	assert.Nil(t, m.Ready())
+
+	m.Checks = append(m.Checks, Check{Name: "moo", Fn: func() error { return errors.New("no cows") }})
+	err := m.Ready()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "moo: no cows")
+
+	m.Checks = nil
+	m.Subscribed(false)
+	assert.NotNil(t, m.Ready())
+}
+
+func Test_Executable(t *testing.T) {
+	shPath, err := exec.LookPath("sh")
+	assert.Nil(t, err)
+	assert.Nil(t, Executable(shPath).Fn())
+
+	notExecutable := filepath.Join(t.TempDir(), "moo")
+	assert.Nil(t, ioutil.WriteFile(notExecutable, []byte("moo"), 0644))
+	assert.NotNil(t, Executable(notExecutable).Fn())
+	assert.NotNil(t, Executable(filepath.Join(t.TempDir(), "missing")).Fn())
+}
+
+func Test_Live(t *testing.T) {
+	var (
+		now = time.Now()
+		m   = &Monitor{StallTimeout: time.Minute, now: func() time.Time { return now }}
+	)
+
+	// idle is not stalled, however long it lasts
+	assert.Nil(t, m.Live())
+	now = now.Add(time.Hour)
+	assert.Nil(t, m.Live())
+
+	m.Received()
+	now = now.Add(30 * time.Second)
+	assert.Nil(t, m.Live())
+
+	// receiving or completing a message is progress
+	m.Received()
+	now = now.Add(45 * time.Second)
+	assert.Nil(t, m.Live())
+
+	now = now.Add(30 * time.Second)
+	assert.NotNil(t, m.Live())
+
+	m.Completed()
+	assert.Nil(t, m.Live())
+	m.Completed()
+	now = now.Add(time.Hour)
+	assert.Nil(t, m.Live())
+
+	// a zero StallTimeout is always live
+	m.StallTimeout = 0
+	m.Received()
+	now = now.Add(time.Hour)
+	assert.Nil(t, m.Live())
+}
+
+func Test_NilMonitor(t *testing.T) {
+	var m *Monitor
+	m.Subscribed(true)
+	m.Received()
+	m.Completed()
+}
+
+func Test_Handlers(t *testing.T) {
+	m := &Monitor{}
+
+	res := httptest.NewRecorder()
+	m.ReadyHandler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, res.Code)
+	assert.Contains(t, res.Body.String(), "no active subscription")
+
+	m.Subscribed(true)
+	res = httptest.NewRecorder()
+	m.ReadyHandler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	res = httptest.NewRecorder()
+	m.LiveHandler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, res.Code)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"derivative-ms/health"
+	"derivative-ms/jobs"
+	"derivative-ms/logging"
+	"derivative-ms/metrics"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// serveHttp listens on addr, and serves the operational endpoints of the application in the background until the
+// answered server is closed:
+//   - /metrics exposes metrics to Prometheus
+//   - /healthz responds 200 if the application is live according to monitor, otherwise 503
+//   - /readyz responds 200 if the application is ready according to monitor, otherwise 503
+//   - /debug/jobs lists the messages recorded by journal, and is only served if journal is not nil
+func serveHttp(addr string, monitor *health.Monitor, journal *jobs.Journal) (*http.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/healthz", monitor.LiveHandler())
+	mux.Handle("/readyz", monitor.ReadyHandler())
+	if journal != nil {
+		mux.Handle("/debug/jobs", journal.Handler())
+	}
+
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
+			logging.Default().Error("server: error serving HTTP", "addr", addr, logging.FieldError, err)
+		}
+	}()
+
+	logging.Default().Info("server: serving HTTP", "addr", l.Addr().String())
+	return server, nil
+}
@@ -0,0 +1,201 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Outcomes of a Job
+const (
+	OutcomeInProgress = "in progress"
+	OutcomeHandled    = "handled"
+	OutcomeUnhandled  = "unhandled"
+	OutcomeFailed     = "failed"
+)
+
+// jobKey keys the *Job carried by a context
+const jobKey = "jobs.job"
+
+// Record describes the handling of a single message: the outcome of each handler, the commands executed, and the
+// requests made to Drupal, in the order they occurred
+type Record struct {
+	MessageId   string
+	Destination string
+	Received    time.Time
+	// Elapsed is the time taken to handle the message, zero while it is in progress
+	Elapsed  time.Duration
+	Outcome  string
+	Error    string
+	Handlers []Handler
+	Commands []Command
+	Requests []Request
+}
+
+// Handler records the outcome of a handler
+type Handler struct {
+	Key string
+	// Claimed is true if the handler claimed the message
+	Claimed bool
+	Elapsed time.Duration
+	Error   string
+}
+
+// Command records a command executed by a handler
+type Command struct {
+	// Line is the command line, with credentials redacted
+	Line string
+	// ExitCode is the exit code of the command, or -1 if it was killed or was not waited for
+	ExitCode int
+	Elapsed  time.Duration
+	// Stderr is the end of the output of the command to stderr
+	Stderr string
+}
+
+// Request records a request made to Drupal
+type Request struct {
+	Method string
+	Uri    string
+	// Code is the status code of the response, or -1 if no response was received
+	Code    int
+	Elapsed time.Duration
+	Error   string
+}
+
+// Job accumulates the Record of a message while it is handled.  A Job is safe for concurrent use, and a nil *Job
+// records nothing, so handlers may record what they do whether or not the message is being journaled.
+type Job struct {
+	mu     sync.Mutex
+	record Record
+}
+
+// WithJob answers a copy of ctx carrying job
+func WithJob(ctx context.Context, job *Job) context.Context {
+	return context.WithValue(ctx, jobKey, job)
+}
+
+// FromContext answers the Job carried by ctx, or nil if ctx does not carry one
+func FromContext(ctx context.Context) *Job {
+	job, _ := ctx.Value(jobKey).(*Job)
+	return job
+}
+
+// Handler records the outcome of a handler
+func (j *Job) Handler(h Handler) {
+	if j == nil {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.record.Handlers = append(j.record.Handlers, h)
+}
+
+// Command records a command executed by a handler
+func (j *Job) Command(c Command) {
+	if j == nil {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.record.Commands = append(j.record.Commands, c)
+}
+
+// Request records a request made to Drupal
+func (j *Job) Request(r Request) {
+	if j == nil {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.record.Requests = append(j.record.Requests, r)
+}
+
+// Finish records the outcome of the message, and the error that caused it to fail, which may be nil
+func (j *Job) Finish(outcome string, err error) {
+	if j == nil {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.record.Outcome = outcome
+	j.record.Elapsed = time.Since(j.record.Received)
+	if err != nil {
+		j.record.Error = err.Error()
+	}
+}
+
+// Record answers a copy of the Record of the job
+func (j *Job) Record() Record {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	r := j.record
+	r.Handlers = append([]Handler(nil), r.Handlers...)
+	r.Commands = append([]Command(nil), r.Commands...)
+	r.Requests = append([]Request(nil), r.Requests...)
+	return r
+}
+
+// Journal keeps the Jobs of the most recently received messages in a ring buffer, so the memory it uses is bounded.  A
+// nil *Journal keeps nothing.
+type Journal struct {
+	mu   sync.Mutex
+	jobs []*Job
+	// next is the index of jobs that the next Job is written to, overwriting the oldest Job once jobs is full
+	next int
+}
+
+// NewJournal answers a Journal keeping the last size Jobs
+func NewJournal(size int) *Journal {
+	return &Journal{jobs: make([]*Job, 0, size)}
+}
+
+// Begin answers a Job recording the handling of a message, which is in progress until Finish is invoked.  If j is
+// nil, the answered Job is nil.
+func (j *Journal) Begin(messageId, destination string) *Job {
+	if j == nil || cap(j.jobs) == 0 {
+		return nil
+	}
+
+	job := &Job{record: Record{
+		MessageId:   messageId,
+		Destination: destination,
+		Received:    time.Now(),
+		Outcome:     OutcomeInProgress,
+	}}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.jobs) < cap(j.jobs) {
+		j.jobs = append(j.jobs, job)
+	} else {
+		j.jobs[j.next] = job
+	}
+	j.next = (j.next + 1) % cap(j.jobs)
+
+	return job
+}
+
+// Records answers the Records of the Jobs kept by the journal, the most recently received first
+func (j *Journal) Records() []Record {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	jobs := make([]*Job, 0, len(j.jobs))
+	for i := 1; i <= len(j.jobs); i++ {
+		jobs = append(jobs, j.jobs[(j.next-i+len(j.jobs))%len(j.jobs)])
+	}
+	j.mu.Unlock()
+
+	records := make([]Record, len(jobs))
+	for i, job := range jobs {
+		records[i] = job.Record()
+	}
+	return records
+}
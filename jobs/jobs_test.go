@@ -0,0 +1,92 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_JournalRing(t *testing.T) {
+	journal := NewJournal(3)
+	assert.Empty(t, journal.Records())
+
+	for i := 0; i < 5; i++ {
+		journal.Begin(fmt.Sprintf("msg-%d", i), "/queue/test")
+	}
+
+	// the oldest jobs are overwritten, and the most recent is answered first
+	var ids []string
+	for _, r := range journal.Records() {
+		ids = append(ids, r.MessageId)
+		assert.Equal(t, OutcomeInProgress, r.Outcome)
+	}
+	assert.Equal(t, []string{"msg-4", "msg-3", "msg-2"}, ids)
+}
+
+func Test_NilJournal(t *testing.T) {
+	var journal *Journal
+	job := journal.Begin("msg-0", "/queue/test")
+	assert.Nil(t, job)
+	assert.Nil(t, journal.Records())
+
+	// a nil job records nothing
+	job.Handler(Handler{Key: "moo"})
+	job.Command(Command{Line: "moo"})
+	job.Request(Request{Method: "GET"})
+	job.Finish(OutcomeHandled, nil)
+
+	assert.Nil(t, FromContext(context.Background()))
+}
+
+func Test_Job(t *testing.T) {
+	journal := NewJournal(1)
+	job := journal.Begin("msg-0", "/queue/test")
+	ctx := WithJob(context.Background(), job)
+
+	FromContext(ctx).Request(Request{Method: "GET", Uri: "http://example.org/moo", Code: 200})
+	FromContext(ctx).Command(Command{Line: "convert - jpg:-", ExitCode: 1, Stderr: "convert: no decode delegate"})
+	FromContext(ctx).Handler(Handler{Key: "ImageMagickHandler", Error: "exit status 1"})
+	job.Finish(OutcomeFailed, errors.New("exit status 1"))
+
+	r := journal.Records()[0]
+	assert.Equal(t, OutcomeFailed, r.Outcome)
+	assert.Equal(t, "exit status 1", r.Error)
+	assert.Equal(t, "http://example.org/moo", r.Requests[0].Uri)
+	assert.Equal(t, 1, r.Commands[0].ExitCode)
+	assert.Equal(t, "ImageMagickHandler", r.Handlers[0].Key)
+
+	// a record is a copy, unaffected by later changes to the job
+	job.Handler(Handler{Key: "moo"})
+	assert.Equal(t, 1, len(r.Handlers))
+}
+
+func Test_JournalHandler(t *testing.T) {
+	journal := NewJournal(2)
+	job := journal.Begin("msg-0", "/queue/test")
+	job.Command(Command{Line: "convert - jpg:-", ExitCode: 1, Stderr: "<script>"})
+	job.Finish(OutcomeFailed, errors.New("moo"))
+
+	res := httptest.NewRecorder()
+	journal.Handler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/debug/jobs", nil))
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.True(t, strings.HasPrefix(res.Header().Get("Content-Type"), "text/html"))
+	body := res.Body.String()
+	assert.Contains(t, body, "msg-0")
+	assert.Contains(t, body, "convert - jpg:-")
+	assert.Contains(t, body, "&lt;script&gt;")
+	assert.NotContains(t, body, "<script>")
+
+	res = httptest.NewRecorder()
+	journal.Handler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/debug/jobs?format=json", nil))
+	var records []Record
+	require.Nil(t, json.Unmarshal(res.Body.Bytes(), &records))
+	assert.Equal(t, "msg-0", records[0].MessageId)
+	assert.Equal(t, "<script>", records[0].Commands[0].Stderr)
+}
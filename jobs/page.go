@@ -0,0 +1,85 @@
+package jobs
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+var page = template.Must(template.New("jobs").Funcs(template.FuncMap{
+	"ms": func(d time.Duration) int64 {
+		return d.Milliseconds()
+	},
+	"time": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04:05.000")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>derivative-ms: recent jobs</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; margin: 1em 2em; }
+table { border-collapse: collapse; margin: 0.5em 0; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.5em; text-align: left; vertical-align: top; }
+pre, code { font-size: 12px; white-space: pre-wrap; word-break: break-all; }
+summary { cursor: pointer; }
+.handled { color: #060; }
+.failed { color: #a00; }
+.unhandled { color: #a60; }
+</style>
+</head>
+<body>
+<h1>Recent jobs</h1>
+<p>The last {{len .}} messages received by this instance, most recent first.  <a href="?format=json">JSON</a></p>
+{{range .}}
+<details>
+<summary>{{time .Received}} <b class="{{.Outcome}}">{{.Outcome}}</b> {{.Destination}} {{.MessageId}}{{if .Elapsed}} ({{ms .Elapsed}} ms){{end}}</summary>
+{{if .Error}}<p class="failed">{{.Error}}</p>{{end}}
+{{if .Handlers}}
+<table>
+<tr><th>Handler</th><th>Result</th><th>Elapsed (ms)</th></tr>
+{{range .Handlers}}<tr><td>{{.Key}}</td><td>{{if .Error}}<span class="failed">{{.Error}}</span>{{else if .Claimed}}<span class="handled">handled</span>{{else}}passed{{end}}</td><td>{{ms .Elapsed}}</td></tr>
+{{end}}</table>
+{{end}}
+{{range .Commands}}
+<table>
+<tr><th>Command</th><td><code>{{.Line}}</code></td></tr>
+<tr><th>Exit code</th><td>{{.ExitCode}}</td></tr>
+<tr><th>Elapsed (ms)</th><td>{{ms .Elapsed}}</td></tr>
+{{if .Stderr}}<tr><th>Stderr</th><td><pre>{{.Stderr}}</pre></td></tr>{{end}}
+</table>
+{{end}}
+{{if .Requests}}
+<table>
+<tr><th>Method</th><th>URI</th><th>Status</th><th>Elapsed (ms)</th></tr>
+{{range .Requests}}<tr><td>{{.Method}}</td><td>{{.Uri}}</td><td>{{if ge .Code 0}}{{.Code}}{{else}}<span class="failed">{{.Error}}</span>{{end}}</td><td>{{ms .Elapsed}}</td></tr>
+{{end}}</table>
+{{end}}
+</details>
+{{else}}
+<p>No messages have been received.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// Handler answers an http.Handler which lists the Records of the journal as an HTML page, or as JSON if the format
+// query parameter is 'json'
+func (j *Journal) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		records := j.Records()
+
+		if r.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(records)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := page.Execute(w, records); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"derivative-ms/api"
 	"derivative-ms/api/stomp"
+	"derivative-ms/health"
+	"derivative-ms/jobs"
 	"fmt"
 	"time"
 )
@@ -20,22 +22,40 @@ type ListenerConfig struct {
 	User, Pass  string
 	AckMode     api.AckMode
 	Proto       api.Proto
-	Verbose     bool
+	Workers     int
+	// UnhandledQueue receives messages that are not claimed by any handler; if empty, unclaimed messages are nacked
+	UnhandledQueue string
+	// ReconnectTimeout is the maximum outage tolerated after the broker connection is lost
+	ReconnectTimeout time.Duration
+	// ShutdownTimeout is the maximum amount of time in-flight messages are given to complete at shutdown
+	ShutdownTimeout time.Duration
+	// Monitor, if not nil, records the state of the subscription and the messages in flight
+	Monitor *health.Monitor
+	// Journal, if not nil, records the handling of recently received messages
+	Journal *jobs.Journal
 }
 
-func Listen(lc *ListenerConfig, handlers []api.Handler) error {
+// Listen connects to the broker and handles messages until ctx is cancelled or the connection to the broker cannot be
+// maintained.  Cancelling ctx begins a graceful shutdown: no new messages are handled, in-flight messages are given
+// ListenerConfig.ShutdownTimeout to complete, and the broker connection is closed.
+func Listen(ctx context.Context, lc *ListenerConfig, handlers []api.Handler) error {
 	if lc.Proto != api.Stomp {
 		return fmt.Errorf("listener: unsupported protocol '%s'", lc.Proto)
 	}
 
 	stompListener := &stomp.ListenerImpl{
-		Host:    lc.BrokerHost,
-		Port:    lc.BrokerPort,
-		User:    lc.User,
-		Pass:    lc.Pass,
-		Queue:   lc.Queue,
-		AckMode: string(lc.AckMode),
-		Debug:   lc.Verbose,
+		Host:             lc.BrokerHost,
+		Port:             lc.BrokerPort,
+		User:             lc.User,
+		Pass:             lc.Pass,
+		Queue:            lc.Queue,
+		AckMode:          string(lc.AckMode),
+		Workers:          lc.Workers,
+		UnhandledQueue:   lc.UnhandledQueue,
+		ReconnectTimeout: lc.ReconnectTimeout,
+		ShutdownTimeout:  lc.ShutdownTimeout,
+		Monitor:          lc.Monitor,
+		Journal:          lc.Journal,
 	}
 
 	if conn, err := api.Dialer(stompListener).Dial(lc.BrokerHost, lc.BrokerPort, lc.DialTimeout); err != nil {
@@ -48,5 +68,5 @@ func Listen(lc *ListenerConfig, handlers []api.Handler) error {
 		return err
 	}
 
-	return api.Listener(stompListener).Listen(context.Background(), handlers)
+	return api.Listener(stompListener).Listen(ctx, handlers)
 }
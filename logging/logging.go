@@ -0,0 +1,280 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Level is the severity of a log record.  Records below the level of a Logger are discarded.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// Format is the encoding of log records
+type Format string
+
+const (
+	// FormatLogfmt writes each record as a line of space-separated key=value pairs
+	FormatLogfmt Format = "logfmt"
+	// FormatJSON writes each record as a line containing a JSON object
+	FormatJSON Format = "json"
+)
+
+// Fields used consistently by the application, so records may be searched without parsing their messages
+const (
+	FieldMessageId   = "message_id"
+	FieldDestination = "destination"
+	FieldHandler     = "handler"
+	FieldSourceUri   = "source_uri"
+	FieldDurationMs  = "duration_ms"
+	FieldError       = "error"
+)
+
+const (
+	fieldTime  = "time"
+	fieldLevel = "level"
+	fieldMsg   = "msg"
+	// badKey is used as the key of a trailing value without a key
+	badKey = "!BADKEY"
+
+	// loggerKey keys the *Logger carried by a context
+	loggerKey = "log.logger"
+)
+
+var (
+	defaultMu     sync.RWMutex
+	defaultLogger = New(os.Stderr, FormatLogfmt, LevelInfo)
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// ParseFormat answers the Format named by s, 'logfmt' or 'json'
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case FormatLogfmt, FormatJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("logging: unknown format '%s', expected '%s' or '%s'", s, FormatLogfmt, FormatJSON)
+}
+
+// output is shared by a Logger and the Loggers derived from it using With
+type output struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format Format
+	level  Level
+}
+
+// Logger writes structured log records, each consisting of a time, level, message, and key-value fields.  A Logger is
+// safe for concurrent use.
+type Logger struct {
+	out *output
+	// fields are alternating keys and values, added to every record
+	fields []interface{}
+}
+
+// New answers a Logger writing records of at least level to w, encoded in format
+func New(w io.Writer, format Format, level Level) *Logger {
+	return &Logger{out: &output{w: w, format: format, level: level}}
+}
+
+// Default answers the Logger used when a context does not carry one
+func Default() *Logger {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultLogger
+}
+
+// SetDefault replaces the Logger answered by Default
+func SetDefault(l *Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLogger = l
+}
+
+// WithLogger answers a copy of ctx carrying l
+func WithLogger(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// FromContext answers the Logger carried by ctx, or Default if ctx does not carry one
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(loggerKey).(*Logger); ok {
+		return l
+	}
+	return Default()
+}
+
+// With answers a Logger which adds the supplied fields, alternating keys and values, to every record
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	return &Logger{out: l.out, fields: append(append(fields, l.fields...), kv...)}
+}
+
+// Enabled answers whether records of level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.out.level
+}
+
+func (l *Logger) Debug(msg string, kv ...interface{}) {
+	l.log(LevelDebug, msg, kv)
+}
+
+func (l *Logger) Info(msg string, kv ...interface{}) {
+	l.log(LevelInfo, msg, kv)
+}
+
+func (l *Logger) Warn(msg string, kv ...interface{}) {
+	l.log(LevelWarn, msg, kv)
+}
+
+func (l *Logger) Error(msg string, kv ...interface{}) {
+	l.log(LevelError, msg, kv)
+}
+
+// Writer answers an io.Writer which writes each line written to it as the message of a record of level, e.g. so the
+// standard library logger may be redirected to l
+func (l *Logger) Writer(level Level) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+			l.log(level, line, nil)
+		}
+		return len(p), nil
+	})
+}
+
+func (l *Logger) log(level Level, msg string, kv []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	fields := append([]interface{}{
+		fieldTime, time.Now().UTC().Format(time.RFC3339Nano),
+		fieldLevel, level.String(),
+		fieldMsg, msg,
+	}, l.fields...)
+	fields = append(fields, kv...)
+	if len(fields)%2 != 0 {
+		fields = append(fields[:len(fields)-1], badKey, fields[len(fields)-1])
+	}
+
+	buf := &bytes.Buffer{}
+	if l.out.format == FormatJSON {
+		encodeJSON(buf, fields)
+	} else {
+		encodeLogfmt(buf, fields)
+	}
+	buf.WriteByte('\n')
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(buf.Bytes())
+}
+
+func encodeJSON(buf *bytes.Buffer, fields []interface{}) {
+	buf.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(fields[i]))
+		buf.Write(key)
+		buf.WriteByte(':')
+
+		value, err := json.Marshal(jsonValue(fields[i+1]))
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprintf("%+v", fields[i+1]))
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+}
+
+// jsonValue answers v in a form that encodes to a useful JSON value: errors and fmt.Stringers are encoded as strings
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+func encodeLogfmt(buf *bytes.Buffer, fields []interface{}) {
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(logfmtKey(fmt.Sprint(fields[i])))
+		buf.WriteByte('=')
+		buf.WriteString(logfmtValue(fields[i+1]))
+	}
+}
+
+// logfmtKey answers key without the characters which would make it ambiguous
+func logfmtKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// logfmtValue answers v, quoted if it is empty or contains characters which would make it ambiguous
+func logfmtValue(v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case nil:
+		s = "null"
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	default:
+		s = fmt.Sprint(v)
+	}
+
+	if s == "" || strings.IndexFunc(s, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == '\\' || !unicode.IsPrint(r)
+	}) >= 0 {
+		return strconv.Quote(s)
+	}
+
+	return s
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,91 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withoutTime removes the time field of logfmt records, which varies
+var withoutTime = regexp.MustCompile(`time=\S+ `)
+
+func Test_Logfmt(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := New(out, FormatLogfmt, LevelInfo).With(FieldMessageId, "msg-1")
+
+	l.Info("moo", FieldSourceUri, "http://example.org/moo.tif", FieldError, errors.New("no cows here"), "count", 2)
+	l.Warn("", "empty", "", "quote", `"moo"`, "nil", nil, "odd")
+
+	assert.Equal(t, `level=info msg=moo message_id=msg-1 source_uri=http://example.org/moo.tif error="no cows here" count=2
+level=warn msg="" message_id=msg-1 empty="" quote="\"moo\"" nil=null !BADKEY=odd
+`, withoutTime.ReplaceAllString(out.String(), ""))
+}
+
+func Test_JSON(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := New(out, FormatJSON, LevelDebug).With(FieldHandler, "houdini")
+
+	l.Debug("moo", FieldDurationMs, int64(12), FieldError, errors.New("no cows"), "backoff", time.Second, "claims", map[string]interface{}{"sub": "moo"})
+
+	record := map[string]interface{}{}
+	require.Nil(t, json.Unmarshal(out.Bytes(), &record))
+	assert.Equal(t, "debug", record["level"])
+	assert.Equal(t, "moo", record["msg"])
+	assert.Equal(t, "houdini", record[FieldHandler])
+	assert.Equal(t, float64(12), record[FieldDurationMs])
+	assert.Equal(t, "no cows", record[FieldError])
+	assert.Equal(t, "1s", record["backoff"])
+	assert.Equal(t, map[string]interface{}{"sub": "moo"}, record["claims"])
+	_, err := time.Parse(time.RFC3339Nano, record["time"].(string))
+	assert.Nil(t, err)
+
+	// the fields of a record are written in order
+	assert.True(t, strings.HasPrefix(out.String(), `{"time":`))
+	assert.True(t, strings.HasSuffix(out.String(), "}\n"))
+}
+
+func Test_Level(t *testing.T) {
+	out := &bytes.Buffer{}
+	l := New(out, FormatLogfmt, LevelWarn)
+
+	l.Debug("debug")
+	l.Info("info")
+	l.Warn("warn")
+	l.Error("error")
+
+	assert.False(t, l.Enabled(LevelInfo))
+	assert.True(t, l.Enabled(LevelError))
+	assert.Equal(t, "level=warn msg=warn\nlevel=error msg=error\n", withoutTime.ReplaceAllString(out.String(), ""))
+}
+
+func Test_Context(t *testing.T) {
+	assert.Equal(t, Default(), FromContext(context.Background()))
+
+	l := New(&bytes.Buffer{}, FormatLogfmt, LevelInfo)
+	assert.Equal(t, l, FromContext(WithLogger(context.Background(), l)))
+}
+
+func Test_Writer(t *testing.T) {
+	out := &bytes.Buffer{}
+	std := log.New(New(out, FormatLogfmt, LevelInfo).Writer(LevelError), "", 0)
+
+	std.Printf("config: moo")
+	assert.Equal(t, "level=error msg=\"config: moo\"\n", withoutTime.ReplaceAllString(out.String(), ""))
+}
+
+func Test_ParseFormat(t *testing.T) {
+	f, err := ParseFormat("JSON")
+	assert.Nil(t, err)
+	assert.Equal(t, FormatJSON, f)
+
+	_, err = ParseFormat("xml")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_Counter(t *testing.T) {
+	r := &Registry{}
+	c := r.NewCounter("moo_total", "Moos by \"cow\"", "cow", "field")
+
+	c.Inc("bessie", "north")
+	c.Add(2.5, "bessie", "north")
+	c.Inc("daisy \"the\" cow", "south")
+
+	assert.Equal(t, 3.5, c.Value("bessie", "north"))
+	assert.Equal(t, float64(0), c.Value("bessie", "south"))
+
+	out := &strings.Builder{}
+	_, err := r.WriteTo(out)
+	require.Nil(t, err)
+	assert.Equal(t, `# HELP moo_total Moos by "cow"
+# TYPE moo_total counter
+moo_total{cow="bessie",field="north"} 3.5
+moo_total{cow="daisy \"the\" cow",field="south"} 1
+`, out.String())
+}
+
+func Test_CounterLabels(t *testing.T) {
+	c := (&Registry{}).NewCounter("moo_total", "Moos", "cow")
+	assert.Panics(t, func() { c.Inc() })
+	assert.Panics(t, func() { c.Inc("bessie", "daisy") })
+}
+
+func Test_Histogram(t *testing.T) {
+	r := &Registry{}
+	h := r.NewHistogram("moo_seconds", "Time spent mooing", []float64{0.5, 1}, "cow")
+
+	h.Observe(0.25, "bessie")
+	h.Observe(0.5, "bessie")
+	h.Observe(0.75, "bessie")
+	h.Observe(2, "bessie")
+
+	assert.Equal(t, uint64(4), h.Count("bessie"))
+	assert.Equal(t, uint64(0), h.Count("daisy"))
+
+	out := &strings.Builder{}
+	_, err := r.WriteTo(out)
+	require.Nil(t, err)
+	assert.Equal(t, `# HELP moo_seconds Time spent mooing
+# TYPE moo_seconds histogram
+moo_seconds_bucket{cow="bessie",le="0.5"} 2
+moo_seconds_bucket{cow="bessie",le="1"} 3
+moo_seconds_bucket{cow="bessie",le="+Inf"} 4
+moo_seconds_sum{cow="bessie"} 3.5
+moo_seconds_count{cow="bessie"} 4
+`, out.String())
+}
+
+func Test_Handler(t *testing.T) {
+	c := NewCounter("metrics_test_total", "Counts requests of Test_Handler")
+	c.Inc()
+
+	res := httptest.NewRecorder()
+	Handler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, contentType, res.Header().Get("Content-Type"))
+	assert.Contains(t, res.Body.String(), "\nmetrics_test_total 1\n")
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"derivative-ms/api"
+	"derivative-ms/api/local"
+	"derivative-ms/config"
+	"derivative-ms/handler"
+	"derivative-ms/logging"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/cristalhq/jwt/v4"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	defaultRunMessageId = "local"
+
+	argMessage = "message"
+	argSource  = "source"
+	argOutput  = "output"
+	argToken   = "token"
+)
+
+// runMain implements the 'run' subcommand, which handles a single message read from a file with the configured handler
+// chain, without a broker.  The source and destination of the message may be substituted by local files, which are
+// served to the handlers by an in-process HTTP server standing in for Drupal.
+func runMain(args []string) {
+	var (
+		flags       = flag.NewFlagSet(fmt.Sprintf("%s %s", os.Args[0], cmdRun), flag.ExitOnError)
+		configFile  = flags.String(argConfig, "", "Path to handler configuration file")
+		message     = flags.String(argMessage, "", "Path to a file containing the JSON message body, e.g. '{\"attachment\": {\"content\": {...}}}'")
+		destination = flags.String(argDestination, "", "Destination the message is handled as if it were received from, e.g. '/queue/islandora-connector-houdini'")
+		messageId   = flags.String(argMessageId, defaultRunMessageId, "Message id supplied to the handlers")
+		source      = flags.String(argSource, "", "Path to a local file substituted for the source_uri of the message")
+		output      = flags.String(argOutput, "", "Path to a local file the derivative is written to, substituted for the destination_uri of the message")
+		rawToken    = flags.String(argToken, "", "JWT carried by the message, without the 'Bearer ' prefix; if empty, the message carries no JWT")
+		verbose     = flags.Bool(argVerbose, false, "Log at the debug level, including the command executed by each handler")
+		logFormat   = flags.String(argLogFormat, string(logging.FormatLogfmt), "Format of log records: 'logfmt' or 'json'")
+		token       *jwt.Token
+		files       *local.FileServer
+		body        []byte
+		err         error
+	)
+	flags.Parse(args)
+	configureLogging(*logFormat, *verbose)
+
+	if *message == "" || *destination == "" {
+		log.Fatalf("run: -%s and -%s are required", argMessage, argDestination)
+	}
+
+	if body, err = ioutil.ReadFile(*message); err != nil {
+		log.Fatalf("run: unable to read message: %s", err)
+	}
+
+	if *rawToken != "" {
+		if token, err = jwt.ParseNoVerify([]byte(strings.TrimPrefix(*rawToken, "Bearer "))); err != nil {
+			log.Fatalf("run: unable to parse -%s: %s", argToken, err)
+		}
+	}
+
+	if *source != "" || *output != "" {
+		files = &local.FileServer{Source: *source, Destination: *output}
+		if err = files.Start(); err != nil {
+			log.Fatalf("run: %s", err)
+		}
+		defer files.Close()
+
+		if body, err = substituteUris(body, files); err != nil {
+			log.Fatalf("run: %s", err)
+		}
+	}
+
+	appConfig := &config.Config{}
+	appConfig.Resolve(*configFile)
+	handlers := configureHandlers(appConfig)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(out, "HANDLER\tRESULT\tELAPSED")
+	l := &local.Listener{
+		MessageId:   *messageId,
+		Destination: *destination,
+		Token:       token,
+		Body:        body,
+		Report: func(r local.Result) {
+			fmt.Fprintf(out, "%s\t%s\t%s\n", describeHandler(r.Handler), describeResult(r), r.Elapsed.Round(time.Millisecond))
+		},
+	}
+
+	err = api.Listener(l).Listen(ctx, handlers)
+	out.Flush()
+
+	if err != nil {
+		log.Fatalf("run: %s", err)
+	}
+
+	if files != nil && files.Destination != "" {
+		if contentType, size := files.Written(); size > 0 {
+			fmt.Printf("wrote %d bytes of %s to %s\n", size, contentType, files.Destination)
+		}
+	}
+}
+
+// substituteUris answers the message body with its source and destination URIs replaced by the URIs of files, for
+// each local file that is supplied.  Other members of the body are preserved.
+func substituteUris(body []byte, files *local.FileServer) ([]byte, error) {
+	msg := map[string]interface{}{}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, fmt.Errorf("unable to parse message: %w", err)
+	}
+
+	attachment, _ := msg["attachment"].(map[string]interface{})
+	if attachment == nil {
+		attachment = map[string]interface{}{}
+		msg["attachment"] = attachment
+	}
+	content, _ := attachment["content"].(map[string]interface{})
+	if content == nil {
+		content = map[string]interface{}{}
+		attachment["content"] = content
+	}
+
+	if files.Source != "" {
+		content["source_uri"] = files.SourceUri()
+	}
+	if files.Destination != "" {
+		content["destination_uri"] = files.DestinationUri()
+	}
+
+	return json.Marshal(msg)
+}
+
+// describeHandler answers the configuration key and type of h, and its timeout if it has one
+func describeHandler(h api.Handler) string {
+	key := ""
+	if mh, ok := h.(*handler.MeteredHandler); ok {
+		key, h = mh.Key+" ", mh.Handler
+	}
+	if th, ok := h.(*handler.TimeoutHandler); ok {
+		return fmt.Sprintf("%s%T (timeout %s)", key, th.Handler, th.Timeout)
+	}
+	return fmt.Sprintf("%s%T", key, h)
+}
+
+func describeResult(r local.Result) string {
+	switch {
+	case r.Err != nil:
+		return fmt.Sprintf("failed: %s", r.Err)
+	case len(r.Claimed) > 0:
+		return fmt.Sprintf("handled as %s", strings.Join(r.Claimed, ", "))
+	default:
+		return "passed"
+	}
+}
@@ -1,14 +1,24 @@
 package main
 
 import (
+	"context"
 	"derivative-ms/api"
 	"derivative-ms/config"
 	"derivative-ms/env"
 	"derivative-ms/handler"
+	"derivative-ms/health"
+	"derivative-ms/jobs"
 	"derivative-ms/listen"
+	"derivative-ms/logging"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"sort"
+	"syscall"
 	"time"
 )
 
@@ -18,21 +28,53 @@ const (
 	defaultAckMode = "client"
 	defaultUser    = ""
 	defaultTimeout = 30
+	defaultWorkers = 1
 
-	argQueue   = "queue"
-	argBroker  = "host"
-	argPort    = "port"
-	argUser    = "user"
-	argPass    = "pass"
-	argAckMode = "ack"
-	argConfig  = "config"
-	argVerbose = "verbose"
+	// default maximum broker outage, in seconds
+	defaultReconnectTimeout = 300
+	// default time allowed for in-flight messages to complete at shutdown, in seconds
+	defaultShutdownTimeout = 25
+	// default time messages may be in flight without progress before the application is not live, in seconds
+	defaultStallTimeout = 3600
+
+	argQueue     = "queue"
+	argBroker    = "host"
+	argPort      = "port"
+	argUser      = "user"
+	argPass      = "pass"
+	argAckMode   = "ack"
+	argConfig    = "config"
+	argVerbose   = "verbose"
+	argWorkers   = "workers"
+	argUnhandled = "unhandled-queue"
+	argHttpAddr  = "http-addr"
+	argLogFormat = "log-format"
+	argDebugJobs = "debug-jobs"
+
+	cmdDlq      = "dlq"
+	cmdHandlers = "handlers"
+	cmdRun      = "run"
 
 	handlerType = "handler-type"
 	order       = "order"
+	timeout     = "timeout"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case cmdDlq:
+			dlqMain(os.Args[2:])
+			return
+		case cmdHandlers:
+			handlersMain(os.Args[2:])
+			return
+		case cmdRun:
+			runMain(os.Args[2:])
+			return
+		}
+	}
+
 	appConfig := &config.Config{
 		Cli: &config.Args{
 			BrokerHost:    flag.String(argBroker, defaultHost, "STOMP broker host name, e.g. 'islandora-idc.traefik.me'"),
@@ -42,12 +84,72 @@ func main() {
 			Pass:          flag.String(argPass, "", "STOMP broker password"),
 			AckMode:       flag.String(argAckMode, defaultAckMode, "STOMP acknowledgment mode, e.g. 'client' or 'auto'"),
 			CliConfigFile: flag.String(argConfig, "", "Path to handler configuration file"),
-			Verbose:       flag.Bool(argVerbose, false, "enable verbose output"),
+			Verbose:       flag.Bool(argVerbose, false, "Log at the debug level, including the STOMP headers and body of each message"),
+			Workers:       flag.Int(argWorkers, defaultWorkers, "Maximum number of messages processed concurrently"),
+			Unhandled:     flag.String(argUnhandled, "", "Queue to send messages that are not handled by any handler, e.g. 'derivative-ms-unhandled'; if empty, unhandled messages are nacked"),
+			HttpAddr:      flag.String(argHttpAddr, "", "Address to serve /metrics, /healthz, and /readyz on, e.g. ':8080'; if empty, no HTTP listener is started"),
+			LogFormat:     flag.String(argLogFormat, string(logging.FormatLogfmt), "Format of log records: 'logfmt' or 'json'"),
+			DebugJobs:     flag.Int(argDebugJobs, 0, "Number of recently received messages to show at /debug/jobs on the -http-addr listener; if zero, the page is not served"),
 		},
 	}
 	flag.Parse()
+	configureLogging(*appConfig.Cli.LogFormat, *appConfig.Cli.Verbose)
 	appConfig.Resolve(*appConfig.Cli.CliConfigFile)
 
+	var err error
+	handlers := configureHandlers(appConfig)
+
+	lc := &listen.ListenerConfig{
+		BrokerHost:       *appConfig.Cli.BrokerHost,
+		BrokerPort:       *appConfig.Cli.BrokerPort,
+		DialTimeout:      time.Duration(env.GetIntOrDefault(config.VarDialTimeoutSeconds, defaultTimeout)) * time.Second,
+		Queue:            *appConfig.Cli.Queue,
+		User:             *appConfig.Cli.User,
+		Pass:             *appConfig.Cli.Pass,
+		AckMode:          api.AckMode(*appConfig.Cli.AckMode),
+		Proto:            api.Stomp,
+		Workers:          *appConfig.Cli.Workers,
+		UnhandledQueue:   *appConfig.Cli.Unhandled,
+		ReconnectTimeout: time.Duration(env.GetIntOrDefault(config.VarReconnectTimeoutSeconds, defaultReconnectTimeout)) * time.Second,
+		ShutdownTimeout:  time.Duration(env.GetIntOrDefault(config.VarShutdownTimeoutSeconds, defaultShutdownTimeout)) * time.Second,
+	}
+
+	monitor := &health.Monitor{
+		StallTimeout: time.Duration(env.GetIntOrDefault(config.VarStallTimeoutSeconds, defaultStallTimeout)) * time.Second,
+		Checks:       commandChecks(appConfig),
+	}
+	lc.Monitor = monitor
+
+	if *appConfig.Cli.DebugJobs > 0 {
+		lc.Journal = jobs.NewJournal(*appConfig.Cli.DebugJobs)
+	}
+
+	var httpServer *http.Server
+	if *appConfig.Cli.HttpAddr != "" {
+		if httpServer, err = serveHttp(*appConfig.Cli.HttpAddr, monitor, lc.Journal); err != nil {
+			log.Fatalf("server: %s", err)
+		}
+	}
+
+	// SIGTERM (e.g. a Kubernetes scale-down) or SIGINT begins a graceful shutdown of the listener
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	err = listen.Listen(ctx, lc, handlers)
+	stop()
+
+	if httpServer != nil {
+		httpServer.Close()
+	}
+
+	if err != nil {
+		log.Fatalf("server: exiting with error %s", err)
+	}
+
+	os.Exit(0)
+}
+
+// configureHandlers instantiates and configures each handler present in the application configuration, answering the
+// handlers sorted by their order.  The application is terminated if any handler cannot be configured.
+func configureHandlers(appConfig *config.Config) []api.Handler {
 	var (
 		handlerConfigs []config.Configuration
 		handlers       []api.Handler
@@ -72,6 +174,11 @@ func main() {
 				Type:   configVal[handlerType].(string),
 			}
 
+			if handlerConfig.Timeout, err = config.DurationValue(&configVal, timeout); err != nil && !errors.Is(err, config.NotFoundErr) {
+				log.Fatalf("error configuring %s: configuration for key %s has an invalid '%s': %s",
+					os.Args[0], configKey, timeout, err)
+			}
+
 			handlerConfigs = append(handlerConfigs, handlerConfig)
 		}
 	}
@@ -81,52 +188,70 @@ func main() {
 
 	// Configure the sorted handlers
 	for _, handlerConfig := range handlerConfigs {
-		var h interface{}
-		switch handlerConfig.Type {
-		case "JWTLoggingHandler":
-			h = &handler.JWTLoggingHandler{}
-		case "JWTHandler":
-			h = &handler.JWTHandler{}
-		case "Pdf2TextHandler":
-			h = &handler.Pdf2TextHandler{}
-		case "TesseractHandler":
-			h = &handler.TesseractHandler{}
-		case "FFMpegHandler":
-			h = &handler.FFMpegHandler{}
-		case "ImageMagickHandler":
-			h = &handler.ImageMagickHandler{}
-		default:
+		registration, ok := handler.Lookup(handlerConfig.Type)
+		if !ok {
 			log.Fatalf("error configuring %s: unknown handler configuration type %s", os.Args[0], handlerConfig.Type)
 		}
+		var h interface{} = registration.New()
 
-		log.Printf("Configuring %s %T", handlerConfig.Key, h)
+		logging.Default().Info("server: configuring handler", logging.FieldHandler, handlerConfig.Key, "type", fmt.Sprintf("%T", h))
 		if configurable, ok := h.(config.Configurable); ok {
 			if err = configurable.Configure(handlerConfig); err != nil {
 				log.Fatalf("error configuring handler %s: %s", handlerConfig.Key, err)
 			}
 		}
 
-		log.Printf("activating handler: %s", handlerConfig.Key)
+		if handlerConfig.Timeout > 0 {
+			h = &handler.TimeoutHandler{Handler: h.(api.Handler), Key: handlerConfig.Key, Timeout: handlerConfig.Timeout}
+		}
+		h = &handler.MeteredHandler{Handler: h.(api.Handler), Key: handlerConfig.Key}
+
+		logging.Default().Info("server: activating handler", logging.FieldHandler, handlerConfig.Key)
 		handlers = append(handlers, h.(api.Handler))
 	}
 
-	lc := &listen.ListenerConfig{
-		BrokerHost:  *appConfig.Cli.BrokerHost,
-		BrokerPort:  *appConfig.Cli.BrokerPort,
-		DialTimeout: time.Duration(env.GetIntOrDefault(config.VarDialTimeoutSeconds, defaultTimeout)) * time.Second,
-		Queue:       *appConfig.Cli.Queue,
-		User:        *appConfig.Cli.User,
-		Pass:        *appConfig.Cli.Pass,
-		AckMode:     api.AckMode(*appConfig.Cli.AckMode),
-		Proto:       api.Stomp,
-		Verbose:     *appConfig.Cli.Verbose,
+	return handlers
+}
+
+// commandChecks answers a health.Check for the 'commandPath' of each handler present in the application configuration,
+// so the application is not ready unless every command can be executed
+func commandChecks(appConfig *config.Config) []health.Check {
+	paths := map[string]struct{}{}
+	for _, value := range appConfig.Json {
+		if handlerConfig, ok := value.(map[string]interface{}); ok {
+			if path, err := config.StringValue(&handlerConfig, "commandPath"); err == nil && path != "" {
+				paths[path] = struct{}{}
+			}
+		}
 	}
 
-	err = listen.Listen(lc, handlers)
+	var checks []health.Check
+	for path := range paths {
+		checks = append(checks, health.Executable(path))
+	}
+
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].Name < checks[j].Name
+	})
+
+	return checks
+}
 
+// configureLogging replaces the default logger with one writing records in the named format, at the debug level if
+// verbose.  Records written using the standard library logger, e.g. fatal errors, are logged at the error level.
+func configureLogging(format string, verbose bool) {
+	f, err := logging.ParseFormat(format)
 	if err != nil {
-		log.Fatalf("server: exiting with error %s", err)
+		log.Fatalf("server: invalid -%s: %s", argLogFormat, err)
 	}
 
-	os.Exit(0)
+	level := logging.LevelInfo
+	if verbose {
+		level = logging.LevelDebug
+	}
+
+	logger := logging.New(os.Stderr, f, level)
+	logging.SetDefault(logger)
+	log.SetFlags(0)
+	log.SetOutput(logger.Writer(logging.LevelError))
 }